@@ -0,0 +1,456 @@
+package alidts
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 归档文件的格式
+const (
+	ARCHIVE_FORMAT_NDJSON = "ndjson"
+	ARCHIVE_FORMAT_CSV    = "csv"
+)
+
+// 归档文件的落盘策略
+const (
+	ARCHIVE_SYNC_NONE  = iota // 不主动fsync, 数据在缓冲区中, 文件关闭或者调用Flush时写入
+	ARCHIVE_SYNC_CLOSE        // 文件滚动或者关闭时fsync
+	ARCHIVE_SYNC_WRITE        // 每写入一条记录fsync一次
+)
+
+const (
+	archiveGzipExt         = ".gz"
+	archiveDefaultMaxAge   = time.Hour
+	archiveSweepInterval   = time.Second
+	archiveEmptyObjectName = "_"
+)
+
+// archiveCSVHeader CSV格式的列, fields, tags和镜像使用JSON编码
+var archiveCSVHeader = []string{
+	"id", "version", "sourceTimestamp", "sourceTxId", "operation", "database", "table", "fields", "tags", "before", "after",
+}
+
+// archiveFile 正在写入的归档文件
+type archiveFile struct {
+	file     *os.File
+	gz       *gzip.Writer
+	buf      *bufio.Writer
+	size     int64
+	openedAt time.Time
+}
+
+// ArchiveWriter 将每个表的所有变更按行写入归档文件, 用于审计
+// 文件路径为dir/db/table/yyyy-mm-dd/HH.ndjson(.gz), 日期和小时取自记录在源库产生的时间,
+// 同一个小时内发生滚动时, 后续的文件名为HH_001.ndjson, HH_002.ndjson, 文件名的字典序即为写入顺序
+type ArchiveWriter struct {
+	dir    string
+	format string
+
+	// Compress 是否使用gzip压缩, 压缩后的文件名增加.gz后缀
+	Compress bool
+	// MaxBytes 单个文件写入的最大字节数(压缩前), <=0表示不限制
+	MaxBytes int64
+	// MaxAge 单个文件的最长写入时间, 超过后关闭文件, 之后的记录写入新的文件
+	MaxAge time.Duration
+	// SyncPolicy 落盘策略, 默认为ARCHIVE_SYNC_CLOSE
+	SyncPolicy int
+	// Location 计算日期和小时使用的时区, 默认为time.Local
+	Location *time.Location
+
+	mu        sync.Mutex
+	files     map[string]*archiveFile
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewArchiveWriter 创建归档写入器, format为ARCHIVE_FORMAT_NDJSON或ARCHIVE_FORMAT_CSV
+func NewArchiveWriter(dir string, format string) (*ArchiveWriter, error) {
+	if format != ARCHIVE_FORMAT_NDJSON && format != ARCHIVE_FORMAT_CSV {
+		return nil, errors.Errorf("unsupported archive format: %s", format)
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "create archive dir")
+	}
+
+	return &ArchiveWriter{
+		dir:        dir,
+		format:     format,
+		MaxAge:     archiveDefaultMaxAge,
+		SyncPolicy: ARCHIVE_SYNC_CLOSE,
+		Location:   time.Local,
+		files:      make(map[string]*archiveFile),
+		now:        time.Now,
+	}, nil
+}
+
+// Write 将记录写入所属表和时间对应的归档文件
+func (w *ArchiveWriter) Write(r *DtsRecord) error {
+	line, err := encodeArchiveLine(w.format, NewChangeEvent(r))
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	err = w.sweep(now)
+	if err != nil {
+		return err
+	}
+
+	base := filepath.Join(w.dir, archiveObjectName(r.Database), archiveObjectName(r.Table),
+		r.GetSourceTime().In(w.Location).Format("2006-01-02/15"))
+	f := w.files[base]
+	if f != nil && w.MaxBytes > 0 && f.size > 0 && f.size+int64(len(line)) > w.MaxBytes {
+		err = w.closeFile(base)
+		if err != nil {
+			return err
+		}
+		f = nil
+	}
+	if f == nil {
+		f, err = w.openFile(base, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := f.buf.Write(line)
+	f.size += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "write archive")
+	}
+
+	if w.SyncPolicy == ARCHIVE_SYNC_WRITE {
+		return f.sync()
+	}
+	return nil
+}
+
+// Flush 将所有打开文件的缓冲写入磁盘并fsync, 一般在提交消费位点之前调用
+func (w *ArchiveWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, f := range w.files {
+		err := f.sync()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭所有打开的文件
+func (w *ArchiveWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var firstErr error
+	for base := range w.files {
+		err := w.closeFile(base)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// sweep 关闭写入时间超过MaxAge的文件, 包括已经过去的小时中不会再写入的文件
+func (w *ArchiveWriter) sweep(now time.Time) error {
+	if now.Sub(w.lastSweep) < archiveSweepInterval {
+		return nil
+	}
+	w.lastSweep = now
+
+	maxAge := w.MaxAge
+	if maxAge <= 0 {
+		maxAge = archiveDefaultMaxAge
+	}
+	for base, f := range w.files {
+		if now.Sub(f.openedAt) < maxAge {
+			continue
+		}
+
+		err := w.closeFile(base)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openFile 打开base对应的下一个不存在的文件, 已经存在的文件不会被追加
+func (w *ArchiveWriter) openFile(base string, now time.Time) (*archiveFile, error) {
+	err := os.MkdirAll(filepath.Dir(base), 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "create archive dir")
+	}
+
+	ext := "." + w.format
+	if w.Compress {
+		ext += archiveGzipExt
+	}
+
+	var file *os.File
+	for seq := 0; ; seq++ {
+		name := base + ext
+		if seq > 0 {
+			name = fmt.Sprintf("%s_%03d%s", base, seq, ext)
+		}
+
+		file, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrap(err, "open archive file")
+		}
+	}
+
+	f := &archiveFile{file: file, openedAt: now}
+	if w.Compress {
+		f.gz = gzip.NewWriter(file)
+		f.buf = bufio.NewWriter(f.gz)
+	} else {
+		f.buf = bufio.NewWriter(file)
+	}
+
+	if w.format == ARCHIVE_FORMAT_CSV {
+		var header bytes.Buffer
+		writer := csv.NewWriter(&header)
+		_ = writer.Write(archiveCSVHeader)
+		writer.Flush()
+		_, err = f.buf.Write(header.Bytes())
+		if err != nil {
+			_ = file.Close()
+			return nil, errors.Wrap(err, "write archive header")
+		}
+	}
+
+	w.files[base] = f
+	return f, nil
+}
+
+func (w *ArchiveWriter) closeFile(base string) error {
+	f := w.files[base]
+	delete(w.files, base)
+
+	err := errors.Wrap(f.buf.Flush(), "flush archive")
+	if err == nil && f.gz != nil {
+		err = errors.Wrap(f.gz.Close(), "close archive gzip")
+	}
+	if err == nil && w.SyncPolicy != ARCHIVE_SYNC_NONE {
+		err = errors.Wrap(f.file.Sync(), "sync archive")
+	}
+	closeErr := f.file.Close()
+	if err == nil && closeErr != nil {
+		err = errors.Wrap(closeErr, "close archive file")
+	}
+	return err
+}
+
+// flush 将缓冲写入文件
+func (f *archiveFile) flush() error {
+	err := f.buf.Flush()
+	if err == nil && f.gz != nil {
+		err = f.gz.Flush()
+	}
+	return errors.Wrap(err, "flush archive")
+}
+
+// sync 将缓冲写入文件并fsync
+func (f *archiveFile) sync() error {
+	err := f.flush()
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(f.file.Sync(), "sync archive")
+}
+
+// archiveObjectName 库名或表名为空时(例如DDL记录)使用占位目录
+func archiveObjectName(name string) string {
+	if name == "" {
+		return archiveEmptyObjectName
+	}
+	return name
+}
+
+// encodeArchiveLine 将ChangeEvent编码成一行
+func encodeArchiveLine(format string, e *ChangeEvent) ([]byte, error) {
+	if format == ARCHIVE_FORMAT_NDJSON {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, errors.Wrap(err, "encode archive record")
+		}
+		return append(line, '\n'), nil
+	}
+
+	row := []string{
+		strconv.FormatInt(e.Id, 10),
+		strconv.Itoa(e.Version),
+		strconv.FormatInt(e.SourceTimeStamp, 10),
+		e.SourceTxId,
+		e.Operation,
+		e.Database,
+		e.Table,
+	}
+	for _, v := range []interface{}{e.Fields, e.Tags, e.Before, e.After} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "encode archive record")
+		}
+		row = append(row, string(data))
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write(row)
+	writer.Flush()
+	return buf.Bytes(), errors.Wrap(writer.Error(), "encode archive record")
+}
+
+// decodeArchiveLine 将一行解码成ChangeEvent, CSV的表头返回nil
+func decodeArchiveLine(format string, line []byte) (*ChangeEvent, error) {
+	var e ChangeEvent
+	if format == ARCHIVE_FORMAT_NDJSON {
+		err := json.Unmarshal(line, &e)
+		return &e, err
+	}
+
+	// JSON编码后的值中没有换行符, 每条记录正好是一行
+	row, err := csv.NewReader(bytes.NewReader(line)).Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(row) != len(archiveCSVHeader) {
+		return nil, errors.Errorf("expect %d columns, got %d", len(archiveCSVHeader), len(row))
+	}
+	if row[0] == archiveCSVHeader[0] {
+		return nil, nil
+	}
+
+	e.Id, err = strconv.ParseInt(row[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	e.Version, err = strconv.Atoi(row[1])
+	if err != nil {
+		return nil, err
+	}
+	e.SourceTimeStamp, err = strconv.ParseInt(row[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	e.SourceTxId, e.Operation, e.Database, e.Table = row[3], row[4], row[5], row[6]
+	for i, v := range []interface{}{&e.Fields, &e.Tags, &e.Before, &e.After} {
+		err = json.Unmarshal([]byte(row[7+i]), v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
+// ReadArchive 按照库名, 表名和写入顺序读取目录中所有的归档记录
+func ReadArchive(dir string, fn func(r *DtsRecord) error) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrap(err, "read archive dir")
+		}
+		if info.IsDir() || archiveFileFormat(path) == "" {
+			return nil
+		}
+		return ReadArchiveFile(path, fn)
+	})
+}
+
+// ReadArchiveFile 读取一个归档文件, 格式和是否压缩由文件名判断
+func ReadArchiveFile(path string, fn func(r *DtsRecord) error) error {
+	format := archiveFileFormat(path)
+	if format == "" {
+		return errors.Errorf("unknown archive file: %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "open archive file")
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(path, archiveGzipExt) {
+		gz, err := gzip.NewReader(f)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "open archive gzip, file: %s", path)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	buf := bufio.NewReader(reader)
+	for {
+		line, err := buf.ReadBytes('\n')
+		// 进程异常退出时gzip文件没有结尾, 已经写入的完整行仍然可以读取
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return errors.Wrapf(err, "read archive file: %s", path)
+		}
+
+		// 最后一行可能没有写完整, 忽略没有换行符的最后一行
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			e, decodeErr := decodeArchiveLine(format, line)
+			if decodeErr != nil {
+				return errors.Wrapf(decodeErr, "decode archive record, file: %s", path)
+			}
+
+			if e != nil {
+				r, recordErr := e.Record()
+				if recordErr != nil {
+					return errors.Wrapf(recordErr, "decode archive record, file: %s", path)
+				}
+
+				fnErr := fn(r)
+				if fnErr != nil {
+					return fnErr
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// archiveFileFormat 根据文件名获取归档格式, 不是归档文件返回空字符串
+func archiveFileFormat(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), archiveGzipExt)
+	for _, format := range []string{ARCHIVE_FORMAT_NDJSON, ARCHIVE_FORMAT_CSV} {
+		if strings.HasSuffix(name, "."+format) {
+			return format
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package alidts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveWriter(t *testing.T) {
+	for _, format := range []string{ARCHIVE_FORMAT_NDJSON, ARCHIVE_FORMAT_CSV} {
+		for _, compress := range []bool{false, true} {
+			dir := t.TempDir()
+			w, err := NewArchiveWriter(dir, format)
+			assert.NoError(t, err)
+			w.Compress = compress
+			w.MaxBytes = 600
+			w.Location = time.UTC
+
+			records := make([]*DtsRecord, 0)
+			for i := int64(1); i <= 5; i++ {
+				r := parseTestMessage(t, newTestInsert(i, i, "tom", "tom@example.com"))
+				records = append(records, r)
+				assert.NoError(t, w.Write(r))
+			}
+			null := newTestInsert(6, 6, "jerry", "")
+			null.after[2] = nil
+			records = append(records, parseTestMessage(t, null))
+			assert.NoError(t, w.Write(records[5]))
+			assert.NoError(t, w.Close())
+
+			ext := "." + format
+			if compress {
+				ext += ".gz"
+			}
+			files, err := filepath.Glob(filepath.Join(dir, "shop", "user", "2020-09-13", "12*"+ext))
+			assert.NoError(t, err)
+			assert.True(t, len(files) > 1, "%s: files should be rotated by size", format)
+			assert.Equal(t, filepath.Join(dir, "shop", "user", "2020-09-13", "12"+ext), files[0])
+
+			index := 0
+			assert.NoError(t, ReadArchive(dir, func(r *DtsRecord) error {
+				expect := records[index]
+				index++
+				assert.Equal(t, expect.Id, r.Id)
+				assert.Equal(t, expect.SourceTxId, r.SourceTxId)
+				assert.Equal(t, "shop", r.Database)
+				assert.Equal(t, "user", r.Table)
+				assert.Equal(t, expect.GetAfterColumns(), r.GetAfterColumns())
+				assert.Equal(t, expect.GetKey("id"), r.GetKey("id"))
+				assert.Equal(t, []string{"id"}, r.GetPrimaryKeyNames())
+				return nil
+			}))
+			assert.Equal(t, len(records), index, format)
+		}
+	}
+}
+
+func TestReadArchiveTruncated(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewArchiveWriter(dir, ARCHIVE_FORMAT_NDJSON)
+	assert.NoError(t, err)
+	w.Location = time.UTC
+	assert.NoError(t, w.Write(parseTestMessage(t, newTestInsert(1, 1, "tom", "tom@example.com"))))
+	assert.NoError(t, w.Close())
+
+	path := filepath.Join(dir, "shop", "user", "2020-09-13", "12.ndjson")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"id":2,"operation":"INS`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	count := 0
+	assert.NoError(t, ReadArchiveFile(path, func(r *DtsRecord) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, 1, count)
+}
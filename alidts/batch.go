@@ -0,0 +1,136 @@
+package alidts
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"utils/parallel"
+)
+
+// ParseResult 一条消息的解析结果, Index为消息在输入中的序号
+type ParseResult struct {
+	Index  int
+	Record *DtsRecord
+	Err    error
+}
+
+type parseJob struct {
+	index  int
+	data   []byte
+	result chan ParseResult
+}
+
+// ParseBatch 使用workers个goroutine并行解析一批消息, 返回的记录和错误与输入的顺序一一对应
+// 解析失败的消息对应的记录为nil, 解析成功的消息对应的错误为nil, workers<=0时使用CPU核数
+func (ad *AliDts) ParseBatch(msgs [][]byte, workers int) ([]*DtsRecord, []error) {
+	in := make(chan []byte, len(msgs))
+	for _, msg := range msgs {
+		in <- msg
+	}
+	close(in)
+
+	records := make([]*DtsRecord, len(msgs))
+	errs := make([]error, len(msgs))
+	for result := range ad.ParseStream(context.Background(), in, workers) {
+		records[result.Index] = result.Record
+		errs[result.Index] = result.Err
+	}
+	return records, errs
+}
+
+// ParseStream 并行解析in中的消息, 按照输入的顺序输出解析结果
+// in被关闭并且所有结果都输出后, 或者ctx被取消后, 输出的channel会被关闭
+func (ad *AliDts) ParseStream(ctx context.Context, in <-chan []byte, workers int) <-chan ParseResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	out := make(chan ParseResult, workers)
+	ctx, cancel := context.WithCancel(ctx)
+
+	var group parallel.Group
+	group.Add(func() error {
+		return ad.runParsePipeline(ctx, in, out, workers)
+	}, func(error) {
+		cancel()
+	})
+	group.Add(func() error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, func(error) {
+		cancel()
+	})
+
+	go func() {
+		defer close(out)
+		_ = group.Run()
+	}()
+
+	return out
+}
+
+// runParsePipeline 分发消息给worker解析, 并按照分发的顺序输出结果
+func (ad *AliDts) runParsePipeline(ctx context.Context, in <-chan []byte, out chan<- ParseResult, workers int) error {
+	jobs := make(chan parseJob, workers)
+	// 按分发顺序保存每条消息结果的channel, 用于保证输出的顺序
+	pending := make(chan chan ParseResult, 2*workers)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				r, err := ad.Parse(job.data)
+				job.result <- ParseResult{Index: job.index, Record: r, Err: err}
+			}
+		}()
+	}
+	defer wg.Wait()
+
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+
+		for index := 0; ; index++ {
+			var data []byte
+			var ok bool
+			select {
+			case data, ok = <-in:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+
+			result := make(chan ParseResult, 1)
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case jobs <- parseJob{index: index, data: data, result: result}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for result := range pending {
+		select {
+		case res := <-result:
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package alidts
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBatch(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	msgs := make([][]byte, 0)
+	for i := int64(1); i <= 100; i++ {
+		data := newTestInsert(i, i, "tom", "tom@example.com").encode()
+		if i%10 == 0 {
+			data = data[:10]
+		}
+		msgs = append(msgs, data)
+	}
+
+	records, errs := ad.ParseBatch(msgs, 4)
+	assert.Len(t, records, 100)
+	for i := range msgs {
+		if (i+1)%10 == 0 {
+			assert.Nil(t, records[i])
+			assert.Error(t, errs[i])
+			continue
+		}
+		assert.NoError(t, errs[i])
+		assert.Equal(t, int64(i+1), records[i].Id)
+	}
+}
+
+func TestParseStreamCancel(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan []byte)
+	out := ad.ParseStream(ctx, in, 2)
+
+	in <- newTestInsert(1, 1, "tom", "tom@example.com").encode()
+	result := <-out
+	assert.Equal(t, 0, result.Index)
+	assert.Equal(t, int64(1), result.Record.Id)
+
+	// 取消后输出的channel会被关闭
+	cancel()
+	for range out {
+	}
+}
@@ -0,0 +1,199 @@
+package alidts
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+
+	"utils"
+)
+
+// TableSchema 表结构, 由DTS消息中的字段定义推断
+type TableSchema struct {
+	Database string
+	Table    string
+	Columns  []ColumnSchema
+}
+
+// ColumnSchema 列的结构, 字段定义中没有是否可为NULL的信息, 观察到NULL值的列会被标记为Nullable
+type ColumnSchema struct {
+	Name     string
+	DataType int
+	Nullable bool
+}
+
+// SchemaCollector 从一批记录中收集表结构
+type SchemaCollector struct {
+	tables map[string]*TableSchema
+}
+
+func NewSchemaCollector() *SchemaCollector {
+	return &SchemaCollector{
+		tables: make(map[string]*TableSchema),
+	}
+}
+
+// Add 从记录中收集表结构, 同一张表以最后一次收到的字段定义为准, 可为NULL的标记会被累积
+func (c *SchemaCollector) Add(r *DtsRecord) {
+	if r == nil || r.Table == "" {
+		return
+	}
+
+	fields := r.loadTableFields()
+	if len(fields) == 0 {
+		return
+	}
+
+	name := r.GetFullTableName()
+	nullable := make(map[string]bool)
+	if table, exist := c.tables[name]; exist {
+		for _, column := range table.Columns {
+			nullable[column.Name] = column.Nullable
+		}
+	}
+
+	table := &TableSchema{Database: r.Database, Table: r.Table}
+	for index, field := range fields {
+		if field == nil {
+			continue
+		}
+
+		column := ColumnSchema{Name: field.Name, DataType: field.DataType, Nullable: nullable[field.Name]}
+		for _, images := range []map[string]interface{}{r.BeforeImages, r.AfterImages} {
+			if array, ok := getImageArray(images); ok && index < len(array) && array[index] == nil {
+				column.Nullable = true
+			}
+		}
+		table.Columns = append(table.Columns, column)
+	}
+	c.tables[name] = table
+}
+
+// AddTable 直接加入表结构, 例如从表的字段定义中读取
+func (c *SchemaCollector) AddTable(table TableSchema) {
+	c.tables[table.Database+"."+table.Table] = &table
+}
+
+// Tables 按照表名排序返回收集到的表结构
+func (c *SchemaCollector) Tables() []TableSchema {
+	names := make([]string, 0, len(c.tables))
+	for name := range c.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tables := make([]TableSchema, 0, len(names))
+	for _, name := range names {
+		tables = append(tables, *c.tables[name])
+	}
+	return tables
+}
+
+// GenerateStructs 为每张表生成带dts tag的Go结构体, 可为NULL的列使用指针类型
+func GenerateStructs(pkg string, tables []TableSchema) ([]byte, error) {
+	// 不同数据库中有同名的表时, 结构体名加上数据库名
+	tableCount := make(map[string]int)
+	for _, table := range tables {
+		tableCount[table.Table]++
+	}
+
+	imports := make(map[string]bool)
+	structNames := make(map[string]bool)
+	var body bytes.Buffer
+	for _, table := range tables {
+		structName := goIdentifier(table.Table)
+		if tableCount[table.Table] > 1 {
+			structName = goIdentifier(table.Database + "_" + table.Table)
+		}
+		structName = uniqueIdentifier(structName, structNames)
+
+		fmt.Fprintf(&body, "// %s %s.%s\n", structName, table.Database, table.Table)
+		fmt.Fprintf(&body, "type %s struct {\n", structName)
+		fieldNames := make(map[string]bool)
+		for _, column := range table.Columns {
+			typ, pkgPath := goType(column.DataType, column.Nullable)
+			if pkgPath != "" {
+				imports[pkgPath] = true
+			}
+			fieldName := uniqueIdentifier(goIdentifier(column.Name), fieldNames)
+			fmt.Fprintf(&body, "%s %s `dts:\"%s\"`\n", fieldName, typ, column.Name)
+		}
+		body.WriteString("}\n\n")
+	}
+
+	var src bytes.Buffer
+	src.WriteString("// Code generated by dtsgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n\n", pkg)
+	if len(imports) > 0 {
+		paths := make([]string, 0, len(imports))
+		for path := range imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		src.WriteString("import (\n")
+		for _, path := range paths {
+			fmt.Fprintf(&src, "%q\n", path)
+		}
+		src.WriteString(")\n\n")
+	}
+	src.Write(body.Bytes())
+
+	return format.Source(src.Bytes())
+}
+
+// goIdentifier 将表名或者列名转换成导出的Go标识符
+func goIdentifier(name string) string {
+	ident := utils.ToCamelCase(name)
+	if ident == "" || (ident[0] >= '0' && ident[0] <= '9') {
+		ident = "F" + ident
+	}
+	return ident
+}
+
+// uniqueIdentifier 不同的名字转换后可能相同, 例如user_id和userId, 重复时加上数字后缀
+func uniqueIdentifier(ident string, used map[string]bool) string {
+	unique := ident
+	for i := 2; used[unique]; i++ {
+		unique = ident + strconv.Itoa(i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// goType mysql类型对应的Go类型, 以及需要导入的包
+func goType(dataType int, nullable bool) (string, string) {
+	var typ, pkgPath string
+	switch dataType {
+	case MYSQL_TYPE_INT8, MYSQL_TYPE_INT16, MYSQL_TYPE_INT24:
+		typ = "int32"
+	case MYSQL_TYPE_INT32, MYSQL_TYPE_INT64:
+		typ = "int64"
+	case MYSQL_TYPE_YEAR:
+		typ = "int"
+	case MYSQL_TYPE_BIT:
+		typ = "uint64"
+	case MYSQL_TYPE_FLOAT:
+		typ = "float32"
+	case MYSQL_TYPE_DOUBLE:
+		typ = "float64"
+	case MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_TIMESTAMP_NEW, MYSQL_TYPE_DATETIME, MYSQL_TYPE_DATETIME_NEW,
+		MYSQL_TYPE_DATE, MYSQL_TYPE_DATE_NEW:
+		typ, pkgPath = "time.Time", "time"
+	case MYSQL_TYPE_JSON:
+		// json.RawMessage本身可以表示null
+		return "json.RawMessage", "encoding/json"
+	case MYSQL_TYPE_GEOMETRY:
+		return "[]byte", ""
+	default:
+		// DECIMAL, TIME, 字符串以及TEXT/BLOB等类型都使用string
+		typ = "string"
+	}
+
+	if nullable {
+		typ = "*" + typ
+	}
+	return typ, pkgPath
+}
@@ -0,0 +1,87 @@
+package alidts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoType(t *testing.T) {
+	for _, c := range []struct {
+		dataType int
+		nullable bool
+		typ      string
+		pkgPath  string
+	}{
+		{MYSQL_TYPE_INT16, false, "int32", ""},
+		{MYSQL_TYPE_INT64, true, "*int64", ""},
+		{MYSQL_TYPE_DOUBLE, false, "float64", ""},
+		{MYSQL_TYPE_DATETIME, true, "*time.Time", "time"},
+		{MYSQL_TYPE_JSON, true, "json.RawMessage", "encoding/json"},
+		{MYSQL_TYPE_GEOMETRY, true, "[]byte", ""},
+		{MYSQL_TYPE_NEWDECIMAL, false, "string", ""},
+		{MYSQL_TYPE_VAR_STRING, true, "*string", ""},
+	} {
+		typ, pkgPath := goType(c.dataType, c.nullable)
+		assert.Equal(t, c.typ, typ, c.dataType)
+		assert.Equal(t, c.pkgPath, pkgPath, c.dataType)
+	}
+}
+
+func TestSchemaCollector(t *testing.T) {
+	c := NewSchemaCollector()
+
+	// 观察到NULL的列标记为可为NULL, 之后的记录不会取消标记
+	null := newTestInsert(1, 10, "tom", "")
+	null.after = []interface{}{int64(10), "tom", nil}
+	c.Add(parseTestMessage(t, null))
+	c.Add(parseTestMessage(t, newTestInsert(2, 20, "jerry", "jerry@example.com")))
+	c.Add(parseTestMessage(t, testMessage{id: 3, operation: OPERATION_COMMIT}))
+	c.AddTable(TableSchema{Database: "crm", Table: "user", Columns: []ColumnSchema{{Name: "id", DataType: MYSQL_TYPE_INT64}}})
+
+	tables := c.Tables()
+	if assert.Len(t, tables, 2) {
+		assert.Equal(t, "crm", tables[0].Database)
+		assert.Equal(t, TableSchema{
+			Database: "shop",
+			Table:    "user",
+			Columns: []ColumnSchema{
+				{Name: "id", DataType: MYSQL_TYPE_INT64},
+				{Name: "name", DataType: MYSQL_TYPE_VAR_STRING},
+				{Name: "email", DataType: MYSQL_TYPE_VAR_STRING, Nullable: true},
+			},
+		}, tables[1])
+	}
+}
+
+func TestGenerateStructs(t *testing.T) {
+	src, err := GenerateStructs("model", []TableSchema{
+		{Database: "crm", Table: "user", Columns: []ColumnSchema{{Name: "id", DataType: MYSQL_TYPE_INT64}}},
+		{Database: "shop", Table: "user", Columns: []ColumnSchema{
+			{Name: "id", DataType: MYSQL_TYPE_INT64},
+			{Name: "user_id", DataType: MYSQL_TYPE_INT64},
+			{Name: "userId", DataType: MYSQL_TYPE_INT64, Nullable: true},
+			{Name: "created_at", DataType: MYSQL_TYPE_DATETIME},
+		}},
+		{Database: "shop", Table: "order_item", Columns: []ColumnSchema{{Name: "1st", DataType: MYSQL_TYPE_JSON}}},
+		{Database: "shop", Table: "orderItem", Columns: []ColumnSchema{{Name: "id", DataType: MYSQL_TYPE_INT64}}},
+	})
+	assert.NoError(t, err)
+
+	code := string(src)
+	assert.True(t, strings.HasPrefix(code, "// Code generated by dtsgen. DO NOT EDIT.\n\npackage model\n"))
+	assert.Contains(t, code, "\t\"encoding/json\"\n\t\"time\"\n")
+	for _, line := range []string{
+		"type CrmUser struct {",
+		"type ShopUser struct {",
+		"\tUserId    int64     `dts:\"user_id\"`",
+		"\tUserId2   *int64    `dts:\"userId\"`",
+		"\tCreatedAt time.Time `dts:\"created_at\"`",
+		"type OrderItem struct {",
+		"\tF1st json.RawMessage `dts:\"1st\"`",
+		"type OrderItem2 struct {",
+	} {
+		assert.Contains(t, code, line+"\n")
+	}
+}
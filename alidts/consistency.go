@@ -0,0 +1,350 @@
+package alidts
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"utils"
+)
+
+// 两个数据流之间差异的类型
+const (
+	DIFF_MISSING  = "missing"  // 只出现在左侧(旧的数据流)
+	DIFF_EXTRA    = "extra"    // 只出现在右侧(新的数据流)
+	DIFF_MISMATCH = "mismatch" // 两侧都有, 但操作类型或者行的值不同
+)
+
+const consistencyDefaultMaxDiffs = 1000
+
+// StreamDiff 一处差异, Left和Right分别为两侧的变化, missing时Right为nil, extra时Left为nil
+type StreamDiff struct {
+	Kind    string
+	Table   string
+	Key     string
+	Left    *RowChange
+	Right   *RowChange
+	Columns []string // 值不同的列, 操作类型不同时为nil
+}
+
+// ConsistencyCounts 比对结果的计数
+type ConsistencyCounts struct {
+	Matched    int64
+	Missing    int64
+	Extra      int64
+	Mismatched int64
+}
+
+// ConsistencyReport 比对的汇总报告
+type ConsistencyReport struct {
+	ConsistencyCounts
+	LeftRecords  int64
+	RightRecords int64
+	Pending      int64                         // 还没有对齐的变化, Finish之后为0
+	Tables       map[string]*ConsistencyCounts // 每张表的计数
+	Diffs        []*StreamDiff                 // 最多保留MaxDiffs条
+}
+
+// Consistent 两个数据流是否一致
+func (r *ConsistencyReport) Consistent() bool {
+	return r.Missing == 0 && r.Extra == 0 && r.Mismatched == 0 && r.Pending == 0
+}
+
+// String 输出汇总信息
+func (r *ConsistencyReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "left: %d, right: %d, matched: %d, missing: %d, extra: %d, mismatched: %d, pending: %d\n",
+		r.LeftRecords, r.RightRecords, r.Matched, r.Missing, r.Extra, r.Mismatched, r.Pending)
+
+	tables := make([]string, 0, len(r.Tables))
+	for table := range r.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		c := r.Tables[table]
+		fmt.Fprintf(&b, "  %s matched: %d, missing: %d, extra: %d, mismatched: %d\n",
+			table, c.Matched, c.Missing, c.Extra, c.Mismatched)
+	}
+	return b.String()
+}
+
+// alignedChanges 对齐键相同的一行在两侧还没有比对的变化
+type alignedChanges struct {
+	table string
+	key   string
+	left  []*comparedChange
+	right []*comparedChange
+}
+
+// comparedChange 等待比对的变化, before和after保留NULL, 比对时NULL和空字符串不同
+type comparedChange struct {
+	*RowChange
+	before map[string]*string
+	after  map[string]*string
+}
+
+// ConsistencyChecker 比对两个DTS数据流(例如迁移前后的两个订阅)产生的逻辑变化是否相同
+// 两侧的记录按照表, 行的唯一键对齐(MatchTx时还包括事务id), 同一行的变化按照到达顺序逐个比对,
+// 只比对INSERT, UPDATE和DELETE, 两侧记录的Id不要求相同
+type ConsistencyChecker struct {
+	mu      sync.Mutex
+	keyFunc KeyFunc
+	pending map[string]*alignedChanges
+	report  ConsistencyReport
+
+	// MatchTx 对齐时是否要求事务id相同, 两个订阅来自同一个源库时可以开启
+	MatchTx bool
+	// MaxDiffs 报告中保留的差异的最大数量, 计数不受影响
+	MaxDiffs int
+}
+
+// NewConsistencyChecker 创建比对器, keyFunc为nil时使用PrimaryKey
+func NewConsistencyChecker(keyFunc KeyFunc) *ConsistencyChecker {
+	if keyFunc == nil {
+		keyFunc = PrimaryKey
+	}
+
+	return &ConsistencyChecker{
+		keyFunc: keyFunc,
+		pending: make(map[string]*alignedChanges),
+		report: ConsistencyReport{
+			Tables: make(map[string]*ConsistencyCounts),
+			Diffs:  make([]*StreamDiff, 0),
+		},
+		MaxDiffs: consistencyDefaultMaxDiffs,
+	}
+}
+
+// AddLeft 加入左侧(旧的数据流)的一条记录
+func (c *ConsistencyChecker) AddLeft(r *DtsRecord) error {
+	return c.add(r, true)
+}
+
+// AddRight 加入右侧(新的数据流)的一条记录
+func (c *ConsistencyChecker) AddRight(r *DtsRecord) error {
+	return c.add(r, false)
+}
+
+// Compare 消费两个数据流直到都被关闭或者ctx被取消, 返回Finish的报告
+func (c *ConsistencyChecker) Compare(ctx context.Context, left, right <-chan *DtsRecord) (*ConsistencyReport, error) {
+	for left != nil || right != nil {
+		var err error
+		select {
+		case r, ok := <-left:
+			if !ok {
+				left = nil
+				continue
+			}
+			err = c.AddLeft(r)
+		case r, ok := <-right:
+			if !ok {
+				right = nil
+				continue
+			}
+			err = c.AddRight(r)
+		case <-ctx.Done():
+			return c.Report(), ctx.Err()
+		}
+		if err != nil {
+			return c.Report(), err
+		}
+	}
+	return c.Finish(), nil
+}
+
+// Report 获取当前的报告, 还没有对齐的变化计入Pending
+func (c *ConsistencyChecker) Report() *ConsistencyReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.snapshot()
+}
+
+// Finish 两个数据流都结束后调用, 还没有对齐的变化分别计为missing和extra
+func (c *ConsistencyChecker) Finish() *ConsistencyReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.pending))
+	for key := range c.pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		aligned := c.pending[key]
+		for _, change := range aligned.left {
+			c.addDiff(&StreamDiff{Kind: DIFF_MISSING, Table: aligned.table, Key: aligned.key, Left: change.RowChange})
+		}
+		for _, change := range aligned.right {
+			c.addDiff(&StreamDiff{Kind: DIFF_EXTRA, Table: aligned.table, Key: aligned.key, Right: change.RowChange})
+		}
+		delete(c.pending, key)
+	}
+	return c.snapshot()
+}
+
+func (c *ConsistencyChecker) add(r *DtsRecord, isLeft bool) error {
+	if r == nil {
+		return nil
+	}
+	switch r.Operation {
+	case OPERATION_INSERT, OPERATION_UPDATE, OPERATION_DELETE:
+	default:
+		return nil
+	}
+
+	table := r.GetFullTableName()
+	key := c.keyFunc(r)
+	if key == "" {
+		return errors.Errorf("can not get row key, table: %s, id: %d", table, r.Id)
+	}
+
+	change := &comparedChange{
+		RowChange: &RowChange{
+			Id:              r.Id,
+			SourceTimeStamp: r.SourceTimeStamp,
+			SourceTxId:      r.SourceTxId,
+			Operation:       r.Operation,
+			Key:             key,
+			Before:          r.GetBeforeColumns(),
+			After:           r.GetAfterColumns(),
+			Changed:         r.GetChangedColumns(),
+		},
+		before: r.getNullableColumns(r.BeforeImages),
+		after:  r.getNullableColumns(r.AfterImages),
+	}
+
+	alignKey := table + KEY_SEPARATOR + key
+	if c.MatchTx {
+		alignKey += KEY_SEPARATOR + r.SourceTxId
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	aligned, exist := c.pending[alignKey]
+	if !exist {
+		aligned = &alignedChanges{table: table, key: key}
+		c.pending[alignKey] = aligned
+	}
+
+	if isLeft {
+		c.report.LeftRecords++
+		aligned.left = append(aligned.left, change)
+	} else {
+		c.report.RightRecords++
+		aligned.right = append(aligned.right, change)
+	}
+
+	for len(aligned.left) > 0 && len(aligned.right) > 0 {
+		c.compare(aligned, aligned.left[0], aligned.right[0])
+		aligned.left = aligned.left[1:]
+		aligned.right = aligned.right[1:]
+	}
+	// 全部对齐后删除, 避免内存随着行数增长
+	if len(aligned.left) == 0 && len(aligned.right) == 0 {
+		delete(c.pending, alignKey)
+	}
+	return nil
+}
+
+// compare 比对同一行的一对变化
+func (c *ConsistencyChecker) compare(aligned *alignedChanges, left, right *comparedChange) {
+	diff := &StreamDiff{
+		Kind:  DIFF_MISMATCH,
+		Table: aligned.table,
+		Key:   aligned.key,
+		Left:  left.RowChange,
+		Right: right.RowChange,
+	}
+
+	if left.Operation == right.Operation {
+		diff.Columns = diffColumns(left.before, right.before)
+		for _, column := range diffColumns(left.after, right.after) {
+			if !utils.IsStringContains(diff.Columns, column) {
+				diff.Columns = append(diff.Columns, column)
+			}
+		}
+		if len(diff.Columns) == 0 {
+			c.report.Matched++
+			c.tableCounts(aligned.table).Matched++
+			return
+		}
+		sort.Strings(diff.Columns)
+	}
+
+	c.addDiff(diff)
+}
+
+func (c *ConsistencyChecker) addDiff(diff *StreamDiff) {
+	counts := c.tableCounts(diff.Table)
+	switch diff.Kind {
+	case DIFF_MISSING:
+		c.report.Missing++
+		counts.Missing++
+	case DIFF_EXTRA:
+		c.report.Extra++
+		counts.Extra++
+	case DIFF_MISMATCH:
+		c.report.Mismatched++
+		counts.Mismatched++
+	}
+
+	if c.MaxDiffs <= 0 || len(c.report.Diffs) < c.MaxDiffs {
+		c.report.Diffs = append(c.report.Diffs, diff)
+	}
+}
+
+func (c *ConsistencyChecker) tableCounts(table string) *ConsistencyCounts {
+	counts, exist := c.report.Tables[table]
+	if !exist {
+		counts = &ConsistencyCounts{}
+		c.report.Tables[table] = counts
+	}
+	return counts
+}
+
+// snapshot 复制当前的报告
+func (c *ConsistencyChecker) snapshot() *ConsistencyReport {
+	report := c.report
+	report.Pending = 0
+	for _, aligned := range c.pending {
+		report.Pending += int64(len(aligned.left) + len(aligned.right))
+	}
+
+	report.Tables = make(map[string]*ConsistencyCounts, len(c.report.Tables))
+	for table, counts := range c.report.Tables {
+		copied := *counts
+		report.Tables[table] = &copied
+	}
+	report.Diffs = append([]*StreamDiff{}, c.report.Diffs...)
+	return &report
+}
+
+// diffColumns 获取两个行中值不同的列, NULL只和NULL相同
+func diffColumns(left, right map[string]*string) []string {
+	columns := make([]string, 0)
+	for name, v := range left {
+		if other, exist := right[name]; !exist || !equalNullable(v, other) {
+			columns = append(columns, name)
+		}
+	}
+	for name := range right {
+		if _, exist := left[name]; !exist {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+func equalNullable(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
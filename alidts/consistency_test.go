@@ -0,0 +1,70 @@
+package alidts
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsistencyChecker(t *testing.T) {
+	changes := newTestChanges()
+	left := make(chan *DtsRecord, 10)
+	for _, m := range changes {
+		left <- parseTestMessage(t, m)
+	}
+	close(left)
+
+	// 右侧的Id不同, 第二条变化的值不同, 缺少最后的DELETE, 多出一行
+	right := make(chan *DtsRecord, 10)
+	for i, m := range changes[:4] {
+		m.id += 100
+		if i == 1 {
+			m.after = []interface{}{int64(10), "tomas", "tom@example.com"}
+		}
+		right <- parseTestMessage(t, m)
+	}
+	right <- parseTestMessage(t, newTestInsert(105, 30, "spike", "spike@example.com"))
+	close(right)
+
+	checker := NewConsistencyChecker(nil)
+	report, err := checker.Compare(context.Background(), left, right)
+	assert.NoError(t, err)
+	assert.False(t, report.Consistent())
+	assert.Equal(t, ConsistencyCounts{Matched: 3, Missing: 1, Extra: 1, Mismatched: 1}, report.ConsistencyCounts)
+	assert.Equal(t, int64(5), report.LeftRecords)
+	assert.Equal(t, int64(5), report.RightRecords)
+	assert.Equal(t, report.ConsistencyCounts, *report.Tables["shop.user"])
+
+	kinds := make(map[string]*StreamDiff)
+	for _, diff := range report.Diffs {
+		kinds[diff.Kind] = diff
+	}
+	if assert.Len(t, kinds, 3) {
+		assert.Equal(t, []string{"name"}, kinds[DIFF_MISMATCH].Columns)
+		assert.Equal(t, "10", kinds[DIFF_MISMATCH].Key)
+		assert.Equal(t, OPERATION_DELETE, kinds[DIFF_MISSING].Left.Operation)
+		assert.Equal(t, "30", kinds[DIFF_EXTRA].Key)
+	}
+	assert.Contains(t, report.String(), "shop.user matched: 3, missing: 1, extra: 1, mismatched: 1")
+}
+
+func TestConsistencyCheckerNull(t *testing.T) {
+	// NULL和空字符串是不同的值
+	null := newTestInsert(1, 10, "tom", "")
+	null.after = []interface{}{int64(10), "tom", nil}
+	empty := newTestInsert(101, 10, "tom", "")
+
+	checker := NewConsistencyChecker(nil)
+	assert.NoError(t, checker.AddLeft(parseTestMessage(t, null)))
+	assert.NoError(t, checker.AddRight(parseTestMessage(t, empty)))
+	// 两侧都是NULL时一致
+	assert.NoError(t, checker.AddLeft(parseTestMessage(t, null)))
+	assert.NoError(t, checker.AddRight(parseTestMessage(t, null)))
+
+	report := checker.Finish()
+	assert.Equal(t, ConsistencyCounts{Matched: 1, Mismatched: 1}, report.ConsistencyCounts)
+	if assert.Len(t, report.Diffs, 1) {
+		assert.Equal(t, []string{"email"}, report.Diffs[0].Columns)
+	}
+}
@@ -0,0 +1,255 @@
+package alidts
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message 从消息队列中获取的一条原始消息
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Value     []byte
+}
+
+// MessageSource 消息来源, 屏蔽具体的kafka客户端
+type MessageSource interface {
+	// Fetch 获取下一条消息, 没有消息时阻塞直到ctx被取消
+	Fetch(ctx context.Context) (*Message, error)
+	// Commit 提交消息的offset, 表示该消息及之前的消息都已经处理完成
+	Commit(ctx context.Context, msg *Message) error
+}
+
+// Handler 处理解析后的记录, 返回错误时消息不会被提交
+type Handler func(ctx context.Context, r *DtsRecord, msg *Message) error
+
+// Consumer 从MessageSource中获取消息, 解析后交给Handler处理, 处理成功后才提交offset
+// 提供at-least-once的语义: 进程在处理完成和提交之间退出时, 重启后消息会被重新投递,
+// 需要严格去重时可以在Handler中配合Deduplicator使用: 开始时用Seen跳过重复记录, 处理成功后再调用Mark
+type Consumer struct {
+	ad      *AliDts
+	source  MessageSource
+	handler Handler
+
+	// DeadLetter 不为nil时, 无法解析的消息写入死信后提交, 否则Run返回错误
+	DeadLetter *DeadLetterWriter
+	// MaxRetries Handler返回错误时的重试次数, 重试全部失败后Run返回错误
+	MaxRetries int
+	// RetryBackoff 第一次重试的等待时间, 之后每次翻倍, 最长不超过MaxBackoff
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	stopped bool
+}
+
+func NewConsumer(ad *AliDts, source MessageSource, handler Handler) *Consumer {
+	return &Consumer{
+		ad:           ad,
+		source:       source,
+		handler:      handler,
+		RetryBackoff: 100 * time.Millisecond,
+		MaxBackoff:   10 * time.Second,
+	}
+}
+
+// Run 持续消费消息, 直到ctx被取消, 调用Stop, 或者出现无法处理的错误
+// 调用Stop停止时, 正在处理的消息会处理并提交完成后再退出, 返回context.Canceled,
+// 正在等待重试的消息不再重试, 不会被提交, 重启后会被重新投递
+func (c *Consumer) Run(ctx context.Context) error {
+	// 处理和提交使用parent, 调用Stop时正在处理的消息不会被中断
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return context.Canceled
+	}
+	c.cancel = cancel
+	c.mu.Unlock()
+
+	for {
+		msg, err := c.source.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.Wrap(err, "fetch message")
+		}
+
+		err = c.handle(parent, ctx, msg)
+		if err != nil {
+			return err
+		}
+
+		err = c.source.Commit(parent, msg)
+		if err != nil {
+			return errors.Wrapf(err, "commit message, topic: %s, partition: %d, offset: %d", msg.Topic, msg.Partition, msg.Offset)
+		}
+	}
+}
+
+// Stop 停止消费
+func (c *Consumer) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// Actor 返回可以加入parallel.Group的actor, 被中断时调用Stop优雅退出
+func (c *Consumer) Actor(ctx context.Context) (execute func() error, interrupt func(error)) {
+	return func() error {
+			return c.Run(ctx)
+		}, func(error) {
+			c.Stop()
+		}
+}
+
+// handle 解析并处理一条消息, Handler使用ctx, 重试的等待在stop被取消时立即结束
+func (c *Consumer) handle(ctx, stop context.Context, msg *Message) error {
+	r, err := c.ad.Parse(msg.Value)
+	if err != nil {
+		if c.DeadLetter == nil {
+			c.observe(nil, HANDLE_RESULT_ERROR)
+			return errors.Wrapf(err, "parse message, topic: %s, partition: %d, offset: %d", msg.Topic, msg.Partition, msg.Offset)
+		}
+
+		c.observe(nil, HANDLE_RESULT_DEAD_LETTER)
+		return c.DeadLetter.Write(msg.Value, err, DeadLetterMeta{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset})
+	}
+
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err = c.handler(ctx, r, msg)
+		if err == nil {
+			c.observe(r, HANDLE_RESULT_OK)
+			return nil
+		}
+
+		if attempt >= c.MaxRetries {
+			c.observe(r, HANDLE_RESULT_ERROR)
+			return errors.Wrapf(err, "handle message, topic: %s, partition: %d, offset: %d", msg.Topic, msg.Partition, msg.Offset)
+		}
+
+		c.observe(r, HANDLE_RESULT_RETRY)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+			backoff *= 2
+			if c.MaxBackoff > 0 && backoff > c.MaxBackoff {
+				backoff = c.MaxBackoff
+			}
+		case <-stop.Done():
+			timer.Stop()
+			return stop.Err()
+		}
+	}
+}
+
+// observe 在AliDts配置了Metrics时记录消息的处理结果
+func (c *Consumer) observe(r *DtsRecord, result string) {
+	if c.ad.metrics != nil {
+		c.ad.metrics.observeHandle(r, result)
+	}
+}
+
+// MemorySource 基于内存的MessageSource, 用于测试
+type MemorySource struct {
+	mu        sync.Mutex
+	messages  []*Message
+	next      int
+	committed map[string]int64
+	notify    chan struct{}
+}
+
+func NewMemorySource(messages ...*Message) *MemorySource {
+	s := &MemorySource{
+		committed: make(map[string]int64),
+		notify:    make(chan struct{}, 1),
+	}
+	s.Add(messages...)
+	return s
+}
+
+// Add 追加消息
+func (s *MemorySource) Add(messages ...*Message) {
+	s.mu.Lock()
+	s.messages = append(s.messages, messages...)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Fetch 实现MessageSource接口, 所有消息都获取完之后阻塞直到有新的消息或者ctx被取消
+func (s *MemorySource) Fetch(ctx context.Context) (*Message, error) {
+	for {
+		s.mu.Lock()
+		if s.next < len(s.messages) {
+			msg := s.messages[s.next]
+			s.next++
+			s.mu.Unlock()
+			return msg, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Commit 实现MessageSource接口
+func (s *MemorySource) Commit(_ context.Context, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed[partitionKey(msg.Topic, msg.Partition)] = msg.Offset
+	return nil
+}
+
+// Committed 获取分区已经提交的offset, 没有提交过时返回-1
+func (s *MemorySource) Committed(topic string, partition int32) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset, exist := s.committed[partitionKey(topic, partition)]
+	if !exist {
+		return -1
+	}
+	return offset
+}
+
+// Rewind 从已经提交的offset之后重新投递消息, 模拟消费者重启或者rebalance
+func (s *MemorySource) Rewind() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next = len(s.messages)
+	for i, msg := range s.messages {
+		offset, exist := s.committed[partitionKey(msg.Topic, msg.Partition)]
+		if !exist || msg.Offset > offset {
+			s.next = i
+			break
+		}
+	}
+}
+
+func partitionKey(topic string, partition int32) string {
+	return fmt.Sprintf("%s/%d", topic, partition)
+}
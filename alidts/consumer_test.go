@@ -0,0 +1,123 @@
+package alidts
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"utils/parallel"
+)
+
+func newTestMessages(count int) []*Message {
+	messages := make([]*Message, 0, count)
+	for i := 0; i < count; i++ {
+		data := newTestInsert(int64(i+1), int64(i+1), "tom", "tom@example.com").encode()
+		messages = append(messages, &Message{Topic: "dts", Partition: 0, Offset: int64(i), Value: data})
+	}
+	return messages
+}
+
+func TestConsumer(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	messages := newTestMessages(5)
+	messages[2].Value = messages[2].Value[:10]
+	source := NewMemorySource(messages...)
+
+	handled := make(chan int64, 10)
+	failed := false
+	consumer := NewConsumer(ad, source, func(ctx context.Context, r *DtsRecord, msg *Message) error {
+		if r.Id == 4 && !failed {
+			failed = true
+			return errors.New("temporary error")
+		}
+		handled <- r.Id
+		return nil
+	})
+	consumer.MaxRetries = 1
+	consumer.RetryBackoff = time.Millisecond
+	deadLetterDir := t.TempDir()
+	consumer.DeadLetter, err = NewDeadLetterWriter(deadLetterDir, 0, 0)
+	assert.NoError(t, err)
+	defer consumer.DeadLetter.Close()
+
+	var group parallel.Group
+	group.Add(consumer.Actor(context.Background()))
+	group.Add(func() error {
+		ids := make([]int64, 0)
+		for len(ids) < 4 {
+			ids = append(ids, <-handled)
+		}
+		assert.Equal(t, []int64{1, 2, 4, 5}, ids)
+		return nil
+	}, func(error) {})
+	assert.NoError(t, group.Run())
+
+	assert.Equal(t, int64(4), source.Committed("dts", 0))
+
+	offsets := make([]int64, 0)
+	assert.NoError(t, ReadDeadLetters(deadLetterDir, func(letter *DeadLetter) error {
+		offsets = append(offsets, letter.Offset)
+		return nil
+	}))
+	assert.Equal(t, []int64{2}, offsets)
+}
+
+func TestConsumerAtLeastOnce(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	source := NewMemorySource(newTestMessages(3)...)
+	handleErr := errors.New("handler error")
+	consumer := NewConsumer(ad, source, func(ctx context.Context, r *DtsRecord, msg *Message) error {
+		if r.Id == 2 {
+			return handleErr
+		}
+		return nil
+	})
+
+	err = consumer.Run(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), source.Committed("dts", 0))
+
+	// 重启后从未提交的消息开始重新投递
+	source.Rewind()
+	msg, err := source.Fetch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), msg.Offset)
+}
+
+func TestConsumerStopDuringBackoff(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	source := NewMemorySource(newTestMessages(1)...)
+	attempts := make(chan struct{}, 10)
+	consumer := NewConsumer(ad, source, func(ctx context.Context, r *DtsRecord, msg *Message) error {
+		attempts <- struct{}{}
+		return errors.New("temporary error")
+	})
+	consumer.MaxRetries = 10
+	consumer.RetryBackoff = time.Hour
+
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.Run(context.Background())
+	}()
+
+	<-attempts
+	consumer.Stop()
+	select {
+	case err = <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop did not interrupt the retry backoff")
+	}
+
+	// 没有处理成功的消息不会被提交
+	assert.Equal(t, int64(-1), source.Committed("dts", 0))
+	assert.Len(t, attempts, 0)
+}
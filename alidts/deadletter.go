@@ -0,0 +1,311 @@
+package alidts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	deadLetterPrefix        = "deadletter-"
+	deadLetterExt           = ".ndjson"
+	deadLetterOpenExt       = ".open"
+	deadLetterReplayedExt   = ".replayed"
+	deadLetterDefaultMaxAge = time.Hour
+)
+
+// 死信中原始数据的类型
+const (
+	DEAD_LETTER_KIND_DTS           = ""              // DTS的avro消息
+	DEAD_LETTER_KIND_WEBHOOK       = "webhook"       // webhook的请求体, Topic为webhook地址
+	DEAD_LETTER_KIND_ELASTICSEARCH = "elasticsearch" // 被拒绝的bulk操作, Topic为索引名
+)
+
+// DeadLetterMeta 死信消息在kafka中的位置
+type DeadLetterMeta struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Kind      string `json:"kind,omitempty"` // Data的类型, 见DEAD_LETTER_KIND_*
+}
+
+// DeadLetter 无法处理的消息, 保存原始消息和错误信息, 修复后可以重放
+type DeadLetter struct {
+	DeadLetterMeta
+	Data      []byte    `json:"data"`
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DeadLetterWriter 将死信按行写入本地目录中的文件, 文件按大小和时间滚动
+// 正在写入的文件带有.open后缀, 关闭或滚动时去掉后缀, 重放只处理已经关闭的文件
+// 写入进程异常退出时遗留的.open文件不会被重放, 确认进程已经退出后去掉后缀即可
+type DeadLetterWriter struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	file     *os.File
+	path     string
+	size     int64
+	openedAt time.Time
+	seq      int
+	lastFile string
+	now      func() time.Time
+}
+
+// NewDeadLetterWriter 创建死信写入器
+// maxBytes为单个文件的最大字节数, <=0表示不限制, maxAge为单个文件的最长写入时间, <=0时为1小时
+func NewDeadLetterWriter(dir string, maxBytes int64, maxAge time.Duration) (*DeadLetterWriter, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "create dead letter dir")
+	}
+
+	if maxAge <= 0 {
+		maxAge = deadLetterDefaultMaxAge
+	}
+
+	return &DeadLetterWriter{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		now:      time.Now,
+	}, nil
+}
+
+// Write 写入一条死信, 写入后立即落盘
+func (w *DeadLetterWriter) Write(data []byte, cause error, meta DeadLetterMeta) error {
+	letter := DeadLetter{
+		DeadLetterMeta: meta,
+		Data:           data,
+		Timestamp:      w.now(),
+	}
+	if cause != nil {
+		letter.Error = cause.Error()
+	}
+
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return errors.Wrap(err, "encode dead letter")
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err = w.rotate(int64(len(line)))
+	if err != nil {
+		return err
+	}
+
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "write dead letter")
+	}
+
+	return errors.Wrap(w.file.Sync(), "sync dead letter")
+}
+
+// Close 关闭当前写入的文件
+func (w *DeadLetterWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	return errors.Wrap(w.closeFile(), "close dead letter file")
+}
+
+// closeFile 关闭当前写入的文件并去掉.open后缀, 调用时需要持有锁
+func (w *DeadLetterWriter) closeFile() error {
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return err
+	}
+	return os.Rename(w.path+deadLetterOpenExt, w.path)
+}
+
+// rotate 当前文件写入size字节后超过限制或者写入时间过长时, 切换到新的文件
+func (w *DeadLetterWriter) rotate(size int64) error {
+	now := w.now()
+	if w.file != nil {
+		if now.Sub(w.openedAt) < w.maxAge && (w.maxBytes <= 0 || w.size+size <= w.maxBytes || w.size == 0) {
+			return nil
+		}
+
+		err := w.closeFile()
+		if err != nil {
+			return errors.Wrap(err, "close dead letter file")
+		}
+	}
+
+	// 同一个写入器的文件名按时间和序号排序即为写入顺序, 文件名中的进程id区分不同进程的写入器,
+	// 同一个进程中的多个写入器或者进程id相同(例如不同容器中)时, 跳过已经存在的文件名
+	var (
+		name string
+		f    *os.File
+	)
+	for {
+		w.seq++
+		name = fmt.Sprintf("%s%s-%d-%06d%s", deadLetterPrefix, now.Format("20060102150405"), os.Getpid(), w.seq, deadLetterExt)
+		if name <= w.lastFile {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(w.dir, name)); err == nil {
+			continue
+		}
+
+		var err error
+		f, err = os.OpenFile(filepath.Join(w.dir, name+deadLetterOpenExt), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return errors.Wrap(err, "open dead letter file")
+		}
+	}
+
+	w.file = f
+	w.path = filepath.Join(w.dir, name)
+	w.size = 0
+	w.openedAt = now
+	w.lastFile = name
+	return nil
+}
+
+// ReadDeadLetters 按照写入顺序读取目录中所有的死信, 包括正在写入的文件
+func ReadDeadLetters(dir string, fn func(letter *DeadLetter) error) error {
+	files, err := listDeadLetterFiles(dir, true)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err = readDeadLetterFile(file, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplayDeadLetters 重新解析目录中的死信并交给handler处理, 一般在修复解析问题后执行
+// 再次失败的死信会写入failed, failed为nil时遇到失败直接返回错误
+// 不是DTS消息的死信(例如webhook的请求体)不会被解析, 原样写入failed, failed为nil时返回错误
+// 每个文件处理完成后会加上.replayed后缀, 避免重复重放, DeadLetterWriter正在写入的文件会被跳过
+func (ad *AliDts) ReplayDeadLetters(dir string, handler func(r *DtsRecord, letter *DeadLetter) error, failed *DeadLetterWriter) error {
+	return replayDeadLetters(dir, failed, func(letter *DeadLetter) error {
+		if letter.Kind != DEAD_LETTER_KIND_DTS {
+			return errors.Errorf("dead letter of kind %q is not a dts message", letter.Kind)
+		}
+
+		r, err := ad.Parse(letter.Data)
+		if err != nil {
+			return err
+		}
+		return handler(r, letter)
+	})
+}
+
+// replayDeadLetters 依次重放目录中的死信, fn返回错误的死信写入failed, failed为nil或者fn返回context的取消错误时直接返回
+func replayDeadLetters(dir string, failed *DeadLetterWriter, fn func(letter *DeadLetter) error) error {
+	files, err := listDeadLetterFiles(dir, false)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err = readDeadLetterFile(file, func(letter *DeadLetter) error {
+			err := fn(letter)
+			if err == nil {
+				return nil
+			}
+
+			cause := errors.Cause(err)
+			if failed == nil || cause == context.Canceled || cause == context.DeadlineExceeded {
+				return errors.Wrapf(err, "replay dead letter, topic: %s, partition: %d, offset: %d", letter.Topic, letter.Partition, letter.Offset)
+			}
+			return failed.Write(letter.Data, err, letter.DeadLetterMeta)
+		})
+		if err != nil {
+			return err
+		}
+
+		err = os.Rename(file, file+deadLetterReplayedExt)
+		if err != nil {
+			return errors.Wrap(err, "mark dead letter file replayed")
+		}
+	}
+	return nil
+}
+
+// listDeadLetterFiles 按照写入顺序列出目录中的死信文件, includeOpen为true时包括正在写入的文件
+func listDeadLetterFiles(dir string, includeOpen bool) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read dead letter dir")
+	}
+
+	files := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, deadLetterPrefix) {
+			continue
+		}
+		if !strings.HasSuffix(name, deadLetterExt) && !(includeOpen && strings.HasSuffix(name, deadLetterExt+deadLetterOpenExt)) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func readDeadLetterFile(path string, fn func(letter *DeadLetter) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "open dead letter file")
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return errors.Wrap(err, "read dead letter file")
+		}
+
+		// 进程异常退出时最后一行可能没有写完整, 忽略没有换行符的最后一行
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var letter DeadLetter
+			decodeErr := json.Unmarshal(line, &letter)
+			if decodeErr != nil {
+				return errors.Wrapf(decodeErr, "decode dead letter, file: %s", path)
+			}
+
+			fnErr := fn(&letter)
+			if fnErr != nil {
+				return fnErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package alidts
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readTestDeadLetters(t *testing.T, dir string) []*DeadLetter {
+	letters := make([]*DeadLetter, 0)
+	assert.NoError(t, ReadDeadLetters(dir, func(letter *DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	}))
+	return letters
+}
+
+func TestDeadLetterWriterRotate(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewDeadLetterWriter(dir, 200, time.Minute)
+	assert.NoError(t, err)
+	now := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	cause := errors.New("broken")
+	for i := int64(0); i < 3; i++ {
+		assert.NoError(t, w.Write([]byte("0123456789"), cause, DeadLetterMeta{Topic: "dts", Offset: i}))
+	}
+	// 超过maxAge后切换文件
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, w.Write([]byte("0123456789"), cause, DeadLetterMeta{Topic: "dts", Offset: 3}))
+	assert.NoError(t, w.Close())
+
+	files, err := listDeadLetterFiles(dir, false)
+	assert.NoError(t, err)
+	assert.Len(t, files, 4)
+	assert.Equal(t, fmt.Sprintf("deadletter-20200913120000-%d-000001.ndjson", os.Getpid()), filepath.Base(files[0]))
+
+	letters := readTestDeadLetters(t, dir)
+	if assert.Len(t, letters, 4) {
+		for i, letter := range letters {
+			assert.Equal(t, int64(i), letter.Offset)
+			assert.Equal(t, "broken", letter.Error)
+			assert.Equal(t, []byte("0123456789"), letter.Data)
+		}
+	}
+}
+
+func TestReadDeadLettersTruncated(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewDeadLetterWriter(dir, 0, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Write([]byte("data"), nil, DeadLetterMeta{Topic: "dts"}))
+	assert.NoError(t, w.Close())
+
+	files, err := listDeadLetterFiles(dir, false)
+	assert.NoError(t, err)
+	f, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"topic":"dts","offs`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	assert.Len(t, readTestDeadLetters(t, dir), 1)
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	newLetters := func() string {
+		dir := t.TempDir()
+		w, err := NewDeadLetterWriter(dir, 0, 0)
+		assert.NoError(t, err)
+		assert.NoError(t, w.Write(newTestInsert(1, 10, "tom", "tom@example.com").encode(), nil, DeadLetterMeta{Offset: 1}))
+		assert.NoError(t, w.Write([]byte("broken"), nil, DeadLetterMeta{Offset: 2}))
+		assert.NoError(t, w.Close())
+		return dir
+	}
+
+	// 没有failed时遇到失败直接返回, 文件不会被标记为已重放
+	dir := newLetters()
+	replayed := make([]int64, 0)
+	handler := func(r *DtsRecord, letter *DeadLetter) error {
+		replayed = append(replayed, r.Id)
+		return nil
+	}
+	assert.Error(t, ad.ReplayDeadLetters(dir, handler, nil))
+	assert.Equal(t, []int64{1}, replayed)
+	assert.Len(t, readTestDeadLetters(t, dir), 2)
+
+	// 再次失败的死信写入failed
+	dir = newLetters()
+	failedDir := t.TempDir()
+	failed, err := NewDeadLetterWriter(failedDir, 0, 0)
+	assert.NoError(t, err)
+	replayed = replayed[:0]
+	assert.NoError(t, ad.ReplayDeadLetters(dir, handler, failed))
+	assert.NoError(t, failed.Close())
+	assert.Equal(t, []int64{1}, replayed)
+	assert.Len(t, readTestDeadLetters(t, dir), 0)
+	if letters := readTestDeadLetters(t, failedDir); assert.Len(t, letters, 1) {
+		assert.Equal(t, int64(2), letters[0].Offset)
+	}
+}
+
+func TestReplayDeadLettersSkipOpenFile(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	w, err := NewDeadLetterWriter(dir, 0, 0)
+	assert.NoError(t, err)
+	defer w.Close()
+	assert.NoError(t, w.Write(newTestInsert(1, 10, "tom", "tom@example.com").encode(), nil, DeadLetterMeta{Offset: 1}))
+
+	// 正在写入的文件带有.open后缀, 其他进程中的重放也不会处理, 之后写入的死信不会丢失
+	files, err := listDeadLetterFiles(dir, false)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+	assert.NoError(t, ad.ReplayDeadLetters(dir, func(r *DtsRecord, letter *DeadLetter) error {
+		t.Fatal("open file should not be replayed")
+		return nil
+	}, nil))
+	assert.NoError(t, w.Write([]byte("broken"), nil, DeadLetterMeta{Offset: 2}))
+	assert.Len(t, readTestDeadLetters(t, dir), 2)
+
+	// 关闭后才能重放
+	assert.NoError(t, w.Close())
+	failed, err := NewDeadLetterWriter(t.TempDir(), 0, 0)
+	assert.NoError(t, err)
+	defer failed.Close()
+	replayed := 0
+	assert.NoError(t, ad.ReplayDeadLetters(dir, func(r *DtsRecord, letter *DeadLetter) error {
+		replayed++
+		return nil
+	}, failed))
+	assert.Equal(t, 1, replayed)
+	assert.Len(t, readTestDeadLetters(t, dir), 0)
+}
+
+func TestDeadLetterWritersSameSecond(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+
+	// 同一秒内创建的多个写入器不会写入同一个文件
+	writers := make([]*DeadLetterWriter, 0)
+	for i := int64(0); i < 3; i++ {
+		w, err := NewDeadLetterWriter(dir, 0, 0)
+		assert.NoError(t, err)
+		w.now = func() time.Time { return now }
+		assert.NoError(t, w.Write([]byte("data"), nil, DeadLetterMeta{Offset: i}))
+		writers = append(writers, w)
+	}
+	for _, w := range writers {
+		assert.NoError(t, w.Close())
+	}
+
+	files, err := listDeadLetterFiles(dir, false)
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+	assert.Len(t, readTestDeadLetters(t, dir), 3)
+
+	// 已经关闭的文件名不会被重新使用
+	w, err := NewDeadLetterWriter(dir, 0, 0)
+	assert.NoError(t, err)
+	w.now = func() time.Time { return now }
+	assert.NoError(t, w.Write([]byte("data"), nil, DeadLetterMeta{Offset: 3}))
+	assert.NoError(t, w.Close())
+	assert.Len(t, readTestDeadLetters(t, dir), 4)
+}
@@ -0,0 +1,238 @@
+package alidts
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"utils"
+)
+
+// Deduplicator 基于记录id的去重器, 用于处理消费者rebalance后DTS重复投递的记录
+// 只保留最近window条记录的id, 超出窗口的id会被淘汰
+//
+// 记录处理成功之后才能调用Mark, 否则处理失败重新投递的记录会被当作重复记录跳过
+//
+// 使用方式:
+//
+//	if dedup.Seen(record) {
+//	    continue
+//	}
+//	err := handle(record)
+//	if err != nil {
+//	    return err
+//	}
+//	dedup.Mark(record)
+type Deduplicator struct {
+	mu     sync.Mutex
+	window int
+	keys   *keyRing // 按先后顺序保存窗口内的key
+	seen   map[string]struct{}
+
+	// 持久化相关
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	written int // 持久化文件中的行数
+}
+
+// NewDeduplicator 创建一个只保存在内存中的去重器
+func NewDeduplicator(window int) *Deduplicator {
+	if window <= 0 {
+		window = 1
+	}
+
+	return &Deduplicator{
+		window: window,
+		keys:   newKeyRing(window),
+		seen:   make(map[string]struct{}, window),
+	}
+}
+
+// NewPersistentDeduplicator 创建一个持久化到本地文件的去重器, 进程重启后会从文件中恢复窗口
+// 每个新的key都会追加写入文件, 当文件行数超过窗口的两倍时会重写文件, 只保留窗口内的key
+func NewPersistentDeduplicator(window int, path string) (*Deduplicator, error) {
+	d := NewDeduplicator(window)
+	d.path = path
+
+	err := d.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// 启动时重写一次文件, 丢弃窗口外的key
+	err = d.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Seen 判断记录是否已经被Mark过
+func (d *Deduplicator) Seen(r *DtsRecord) bool {
+	if r == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, exist := d.seen[dedupKey(r)]
+	return exist
+}
+
+// Mark 记录处理成功后调用, 将记录加入窗口
+func (d *Deduplicator) Mark(r *DtsRecord) {
+	if r == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := dedupKey(r)
+	if _, exist := d.seen[key]; exist {
+		return
+	}
+
+	d.add(key)
+
+	if d.writer != nil {
+		err := d.persist(key)
+		if err != nil {
+			// 持久化失败不影响去重的结果, 只是重启后可能无法识别这条记录
+			utils.Print("error", "deduplicator persist key", "path", d.path, "err", err)
+		}
+	}
+}
+
+// Len 当前窗口内的key数量
+func (d *Deduplicator) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.seen)
+}
+
+// Close 将缓冲的数据写入文件并关闭文件
+func (d *Deduplicator) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.file == nil {
+		return nil
+	}
+
+	err := d.writer.Flush()
+	if err != nil {
+		d.file.Close()
+		return err
+	}
+
+	err = d.file.Close()
+	d.file = nil
+	d.writer = nil
+	return err
+}
+
+// dedupKey 记录的去重key, 由事务id和记录id组成
+func dedupKey(r *DtsRecord) string {
+	return r.SourceTxId + ":" + strconv.FormatInt(r.Id, 10)
+}
+
+// add 将key加入窗口, 窗口满时淘汰最早的key
+func (d *Deduplicator) add(key string) {
+	if old := d.keys.add(key); old != "" {
+		delete(d.seen, old)
+	}
+	d.seen[key] = struct{}{}
+}
+
+func (d *Deduplicator) persist(key string) error {
+	_, err := d.writer.WriteString(key + "\n")
+	if err != nil {
+		return err
+	}
+
+	err = d.writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	d.written++
+	if d.written > 2*d.window {
+		return d.compact()
+	}
+	return nil
+}
+
+// load 从文件中恢复窗口
+func (d *Deduplicator) load() error {
+	f, err := os.Open(d.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "open dedup file")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key := scanner.Text()
+		if key == "" {
+			continue
+		}
+		if _, exist := d.seen[key]; !exist {
+			d.add(key)
+		}
+	}
+
+	return errors.Wrap(scanner.Err(), "read dedup file")
+}
+
+// compact 将窗口内的key写入临时文件, 然后替换原来的文件
+func (d *Deduplicator) compact() error {
+	if d.file != nil {
+		d.writer.Flush()
+		d.file.Close()
+		d.file = nil
+		d.writer = nil
+	}
+
+	tmpPath := d.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "create dedup file")
+	}
+
+	keys := d.keys.ordered()
+	w := bufio.NewWriter(tmp)
+	for _, key := range keys {
+		w.WriteString(key + "\n")
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err != nil {
+		return errors.Wrap(err, "write dedup file")
+	}
+
+	err = os.Rename(tmpPath, d.path)
+	if err != nil {
+		return errors.Wrap(err, "rename dedup file")
+	}
+
+	d.file, err = os.OpenFile(d.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "open dedup file")
+	}
+	d.writer = bufio.NewWriter(d.file)
+	d.written = len(keys)
+	return nil
+}
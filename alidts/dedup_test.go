@@ -0,0 +1,52 @@
+package alidts
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduplicatorWindow(t *testing.T) {
+	d := NewDeduplicator(2)
+
+	r1 := &DtsRecord{Id: 1, SourceTxId: "tx1"}
+	r2 := &DtsRecord{Id: 2, SourceTxId: "tx1"}
+	r3 := &DtsRecord{Id: 3, SourceTxId: "tx2"}
+
+	// 处理失败没有Mark的记录重新投递时不会被跳过
+	assert.False(t, d.Seen(r1))
+	assert.False(t, d.Seen(r1))
+	d.Mark(r1)
+	assert.True(t, d.Seen(r1))
+
+	d.Mark(r2)
+	d.Mark(r3)
+	assert.Equal(t, 2, d.Len())
+
+	// r1已经被淘汰出窗口
+	assert.False(t, d.Seen(r1))
+	assert.True(t, d.Seen(r3))
+}
+
+func TestPersistentDeduplicator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+
+	d, err := NewPersistentDeduplicator(3, path)
+	assert.NoError(t, err)
+	for i := int64(1); i <= 10; i++ {
+		r := &DtsRecord{Id: i}
+		assert.False(t, d.Seen(r))
+		d.Mark(r)
+	}
+	assert.NoError(t, d.Close())
+
+	d, err = NewPersistentDeduplicator(3, path)
+	assert.NoError(t, err)
+	defer d.Close()
+
+	assert.Equal(t, 3, d.Len())
+	assert.True(t, d.Seen(&DtsRecord{Id: 10}))
+	assert.True(t, d.Seen(&DtsRecord{Id: 8}))
+	assert.False(t, d.Seen(&DtsRecord{Id: 7}))
+}
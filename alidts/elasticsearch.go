@@ -0,0 +1,419 @@
+package alidts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// bulkAction 一条bulk操作, 包含操作行和可选的文档行
+type bulkAction struct {
+	op    string
+	index string
+	id    string
+	body  []byte
+}
+
+// bulkRejection 被Elasticsearch拒绝的操作, 重试也不会成功, 例如mapping错误
+type bulkRejection struct {
+	action bulkAction
+	status int
+	reason string
+}
+
+func (r bulkRejection) Error() string {
+	return fmt.Sprintf("bulk %s rejected, index: %s, id: %s, status: %d, error: %s", r.action.op, r.action.index, r.action.id, r.status, r.reason)
+}
+
+// ElasticsearchSink 将记录转换成Elasticsearch的_bulk请求写入索引
+// INSERT/FILL对应index, UPDATE对应update(doc_as_upsert), DELETE对应delete, 文档的_id为行的唯一键
+// 缓冲的数据达到BatchBytes或者每隔FlushInterval会被提交一次
+// Write返回nil只表示记录进入了缓冲, 需要at-least-once时应该在Flush成功之后再提交消费位点
+type ElasticsearchSink struct {
+	endpoint string
+
+	// Client 发送请求使用的http客户端
+	Client *http.Client
+	// KeyFunc 计算文档_id, 默认为PrimaryKey
+	KeyFunc KeyFunc
+	// IndexTemplate 索引名模板, 支持{db}和{table}占位符, 默认为"{db}.{table}", 索引名会被转换成小写
+	IndexTemplate string
+	// BatchBytes 缓冲的请求体达到该大小时立即提交
+	BatchBytes int
+	// FlushInterval 定时提交的间隔, 由Actor使用
+	FlushInterval time.Duration
+	// MaxRetries 请求失败或者部分文档返回429/5xx时的重试次数
+	MaxRetries int
+	// RetryBackoff 第一次重试的等待时间, 之后每次翻倍
+	RetryBackoff time.Duration
+	// DeadLetter 不为nil时, 被拒绝的操作(例如mapping错误)写入死信, Topic为索引名, 否则Flush返回错误
+	DeadLetter *DeadLetterWriter
+
+	mu           sync.Mutex
+	pending      []bulkAction
+	pendingBytes int
+	flushMu      sync.Mutex
+}
+
+// NewElasticsearchSink 创建sink, endpoint为Elasticsearch的地址, 例如http://127.0.0.1:9200
+func NewElasticsearchSink(endpoint string) *ElasticsearchSink {
+	return &ElasticsearchSink{
+		endpoint:      strings.TrimRight(endpoint, "/") + "/_bulk",
+		Client:        http.DefaultClient,
+		KeyFunc:       PrimaryKey,
+		IndexTemplate: "{db}.{table}",
+		BatchBytes:    5 * 1024 * 1024,
+		FlushInterval: time.Second,
+		MaxRetries:    3,
+		RetryBackoff:  100 * time.Millisecond,
+	}
+}
+
+// Write 将记录转换成bulk操作并加入缓冲, 非DML记录会被忽略
+func (s *ElasticsearchSink) Write(ctx context.Context, r *DtsRecord) error {
+	actions, err := s.buildActions(r)
+	if err != nil || len(actions) == 0 {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, action := range actions {
+		s.pending = append(s.pending, action)
+		s.pendingBytes += len(action.body)
+	}
+	full := s.pendingBytes >= s.BatchBytes
+	s.mu.Unlock()
+
+	if full {
+		return s.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush 提交缓冲中所有的操作
+// 重试全部失败, 请求整体被拒绝或者ctx被取消时, 没有成功的操作会放回缓冲的最前面, 下次Flush时重新提交
+// 单个操作被拒绝时不影响其他操作, 被拒绝的操作写入DeadLetter, DeadLetter为nil时返回错误
+func (s *ElasticsearchSink) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	actions := s.pending
+	s.pending = nil
+	s.pendingBytes = 0
+	s.mu.Unlock()
+
+	backoff := s.RetryBackoff
+	for attempt := 0; len(actions) > 0; attempt++ {
+		failed, rejected, err := s.bulk(ctx, actions)
+		if err != nil {
+			failed = actions
+		}
+
+		rejectErr := s.reject(rejected)
+		if rejectErr != nil {
+			s.requeue(failed)
+			return errors.Wrap(rejectErr, "elasticsearch bulk")
+		}
+
+		if err == nil && len(failed) == 0 {
+			return nil
+		}
+
+		if _, ok := err.(permanentError); ok {
+			s.requeue(failed)
+			return errors.Wrap(err, "elasticsearch bulk")
+		}
+
+		// 请求整体失败时重试所有的操作, 否则只重试返回429/5xx的操作
+		actions = failed
+
+		if attempt >= s.MaxRetries {
+			s.requeue(actions)
+			if err == nil {
+				err = errors.Errorf("%d bulk actions failed", len(actions))
+			}
+			return errors.Wrap(err, "elasticsearch bulk")
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+			backoff *= 2
+		case <-ctx.Done():
+			timer.Stop()
+			s.requeue(actions)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// reject 将被拒绝的操作写入死信, 没有设置DeadLetter时返回第一个被拒绝的操作
+func (s *ElasticsearchSink) reject(rejected []bulkRejection) error {
+	if len(rejected) == 0 {
+		return nil
+	}
+	if s.DeadLetter == nil {
+		return errors.Wrapf(rejected[0], "%d bulk actions rejected", len(rejected))
+	}
+
+	for _, r := range rejected {
+		err := s.DeadLetter.Write(r.action.body, r, DeadLetterMeta{Topic: r.action.index, Kind: DEAD_LETTER_KIND_ELASTICSEARCH})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requeue 将没有提交成功的操作放回缓冲的最前面, 保持操作的顺序
+func (s *ElasticsearchSink) requeue(actions []bulkAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = append(append([]bulkAction{}, actions...), s.pending...)
+	for _, action := range actions {
+		s.pendingBytes += len(action.body)
+	}
+}
+
+// Actor 返回可以加入parallel.Group的actor, 定时提交缓冲, 被中断时提交剩余的数据后退出
+func (s *ElasticsearchSink) Actor(ctx context.Context) (execute func() error, interrupt func(error)) {
+	stop := make(chan struct{})
+	var once sync.Once
+	return func() error {
+			ticker := time.NewTicker(s.FlushInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					err := s.Flush(ctx)
+					if err != nil {
+						return err
+					}
+				case <-stop:
+					return s.Flush(ctx)
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}, func(error) {
+			once.Do(func() {
+				close(stop)
+			})
+		}
+}
+
+// bulkResponse _bulk接口的返回
+type bulkResponse struct {
+	Errors bool                            `json:"errors"`
+	Items  []map[string]bulkResponseStatus `json:"items"`
+}
+
+type bulkResponseStatus struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// permanentError 重试也不会成功的错误, 例如400, 文档的mapping错误等
+type permanentError struct {
+	error
+}
+
+// bulk 发送一次bulk请求, 请求体过大(413)时拆成两半依次发送, 只有一个操作时该操作被拒绝
+// 返回错误表示请求整体失败, 所有的操作都需要重试
+func (s *ElasticsearchSink) bulk(ctx context.Context, actions []bulkAction) ([]bulkAction, []bulkRejection, error) {
+	failed, rejected, err := s.send(ctx, actions)
+	if !isRequestTooLarge(err) {
+		return failed, rejected, err
+	}
+
+	if len(actions) == 1 {
+		return nil, []bulkRejection{{action: actions[0], status: http.StatusRequestEntityTooLarge, reason: err.Error()}}, nil
+	}
+
+	half := len(actions) / 2
+	failed, rejected, err = s.bulk(ctx, actions[:half])
+	if err != nil {
+		return nil, nil, err
+	}
+	// 前一半有需要重试的操作时, 后一半整体重试, 保持同一个文档的操作顺序
+	if len(failed) > 0 {
+		return append(failed, actions[half:]...), rejected, nil
+	}
+
+	rest, restRejected, err := s.bulk(ctx, actions[half:])
+	if err != nil {
+		rest = actions[half:]
+	}
+	return rest, append(rejected, restRejected...), nil
+}
+
+func isRequestTooLarge(err error) bool {
+	e, ok := err.(permanentError)
+	if !ok {
+		return false
+	}
+	_, ok = e.error.(requestTooLargeError)
+	return ok
+}
+
+// requestTooLargeError 请求体超过了http.max_content_length
+type requestTooLargeError struct {
+	error
+}
+
+// send 发送一次bulk请求, 返回需要重试的操作和被拒绝的操作
+// 一个操作需要重试时, 之后同一个文档的操作即使已经成功也需要一起重试, 否则重试会用旧的数据覆盖新的数据
+// 删除不存在的文档(404)视为成功, 重复投递或者唯一键被修改后都会出现
+// 返回错误表示请求整体失败
+func (s *ElasticsearchSink) send(ctx context.Context, actions []bulkAction) ([]bulkAction, []bulkRejection, error) {
+	var body bytes.Buffer
+	for _, action := range actions {
+		body.Write(action.body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.Errorf("unexpected status: %d, body: %s", resp.StatusCode, truncateBytes(data, 512))
+		switch {
+		case resp.StatusCode == http.StatusRequestEntityTooLarge:
+			return nil, nil, permanentError{requestTooLargeError{err}}
+		case resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500:
+			return nil, nil, permanentError{err}
+		}
+		return nil, nil, err
+	}
+
+	var result bulkResponse
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "decode bulk response")
+	}
+
+	if !result.Errors {
+		return nil, nil, nil
+	}
+
+	var failed []bulkAction
+	var rejected []bulkRejection
+	failedDocs := make(map[string]bool)
+	for i, item := range result.Items {
+		if i >= len(actions) {
+			break
+		}
+
+		action := actions[i]
+		doc := action.index + "/" + action.id
+		for _, status := range item {
+			switch {
+			case status.Status < 300, status.Status == http.StatusNotFound && action.op == "delete":
+				if failedDocs[doc] {
+					failed = append(failed, action)
+				}
+			case status.Status == http.StatusTooManyRequests || status.Status >= 500:
+				failed = append(failed, action)
+				failedDocs[doc] = true
+			default:
+				rejected = append(rejected, bulkRejection{action: action, status: status.Status, reason: string(status.Error)})
+			}
+		}
+	}
+	return failed, rejected, nil
+}
+
+// buildActions 将记录转换成bulk操作
+func (s *ElasticsearchSink) buildActions(r *DtsRecord) ([]bulkAction, error) {
+	switch r.Operation {
+	case OPERATION_INSERT, OPERATION_FILL, OPERATION_UPDATE, OPERATION_DELETE:
+	default:
+		return nil, nil
+	}
+
+	index, ok := renderTemplate(s.IndexTemplate, func(name string) (string, bool) {
+		switch name {
+		case "db":
+			return r.Database, true
+		case "table":
+			return r.Table, true
+		}
+		return "", false
+	})
+	if !ok {
+		return nil, errors.Errorf("invalid index template: %s", s.IndexTemplate)
+	}
+	index = strings.ToLower(index)
+
+	id := s.KeyFunc(r)
+	if id == "" {
+		return nil, errors.Errorf("can not get document id, table: %s, id: %d", r.GetFullTableName(), r.Id)
+	}
+
+	if r.Operation == OPERATION_DELETE {
+		return []bulkAction{newBulkAction("delete", index, id, nil)}, nil
+	}
+
+	after := r.GetAfterColumns()
+	if after == nil {
+		return nil, errors.Errorf("missing after image, table: %s, id: %d", r.GetFullTableName(), r.Id)
+	}
+
+	if r.Operation != OPERATION_UPDATE {
+		return []bulkAction{newBulkAction("index", index, id, after)}, nil
+	}
+
+	actions := make([]bulkAction, 0, 2)
+	// 唯一键被修改时需要删除旧的文档
+	if oldId := r.GetBeforeKey(s.KeyFunc); oldId != "" && oldId != id {
+		actions = append(actions, newBulkAction("delete", index, oldId, nil))
+	}
+	doc := map[string]interface{}{"doc": after, "doc_as_upsert": true}
+	return append(actions, newBulkAction("update", index, id, doc)), nil
+}
+
+func newBulkAction(op, index, id string, doc interface{}) bulkAction {
+	meta := map[string]map[string]string{op: {"_index": index, "_id": id}}
+	line, _ := json.Marshal(meta)
+	body := append(line, '\n')
+
+	if doc != nil {
+		line, _ = json.Marshal(doc)
+		body = append(body, line...)
+		body = append(body, '\n')
+	}
+	return bulkAction{op: op, index: index, id: id, body: body}
+}
+
+// truncateBytes 截断过长的内容, 用于错误信息
+func truncateBytes(data []byte, max int) string {
+	if len(data) <= max {
+		return string(data)
+	}
+	return string(data[:max]) + "..."
+}
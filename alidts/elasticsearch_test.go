@@ -0,0 +1,269 @@
+package alidts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElasticsearchSink(t *testing.T) {
+	var mu sync.Mutex
+	requests := make([][]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/_bulk", req.URL.Path)
+
+		lines := make([]string, 0)
+		scanner := bufio.NewScanner(req.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+
+		mu.Lock()
+		requests = append(requests, lines)
+		attempt := len(requests)
+		mu.Unlock()
+
+		switch attempt {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// 第二个操作返回429, 需要单独重试
+			w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},{"delete":{"status":429}},{"update":{"status":200}}]}`))
+		default:
+			w.Write([]byte(`{"errors":false,"items":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	sink := NewElasticsearchSink(server.URL)
+	sink.RetryBackoff = 0
+	sink.IndexTemplate = "{db}-{table}"
+
+	update := newTestInsert(3, 11, "jerry", "jerry@example.com")
+	update.operation = OPERATION_UPDATE
+	update.before = []interface{}{int64(10), "jerry", "jerry@example.com"}
+
+	ctx := context.Background()
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, newTestInsert(1, 10, "tom", "tom@example.com"))))
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, update)))
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, testMessage{id: 4, operation: OPERATION_HEARTBEAT})))
+	assert.NoError(t, sink.Flush(ctx))
+
+	assert.Len(t, requests, 3)
+	assert.Equal(t, requests[0], requests[1])
+	assert.Equal(t, []string{`{"delete":{"_id":"10","_index":"shop-user"}}`}, requests[2])
+
+	lines := requests[1]
+	assert.Len(t, lines, 5)
+	assert.Equal(t, `{"index":{"_id":"10","_index":"shop-user"}}`, lines[0])
+	assert.Equal(t, `{"update":{"_id":"11","_index":"shop-user"}}`, lines[3])
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[4]), &doc))
+	assert.Equal(t, true, doc["doc_as_upsert"])
+	assert.True(t, strings.Contains(lines[4], `"name":"jerry"`))
+}
+
+func TestElasticsearchSinkPermanentError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"errors":true,"items":[{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+	}))
+	defer server.Close()
+
+	sink := NewElasticsearchSink(server.URL)
+	ctx := context.Background()
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, newTestInsert(1, 10, "tom", "tom@example.com"))))
+	err := sink.Flush(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "mapper_parsing_exception")
+	}
+}
+
+func TestElasticsearchSinkRetryKeepsOrder(t *testing.T) {
+	var mu sync.Mutex
+	requests := make([][]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lines := make([]string, 0)
+		scanner := bufio.NewScanner(req.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+
+		mu.Lock()
+		requests = append(requests, lines)
+		attempt := len(requests)
+		mu.Unlock()
+
+		if attempt == 1 {
+			// 第一个操作返回429, 之后同一个文档的update已经成功
+			w.Write([]byte(`{"errors":true,"items":[{"index":{"status":429}},{"update":{"status":200}}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	sink := NewElasticsearchSink(server.URL)
+	sink.RetryBackoff = 0
+
+	update := newTestInsert(2, 10, "v2", "tom@example.com")
+	update.operation = OPERATION_UPDATE
+	update.before = []interface{}{int64(10), "v1", "tom@example.com"}
+
+	ctx := context.Background()
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, newTestInsert(1, 10, "v1", "tom@example.com"))))
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, update)))
+	assert.NoError(t, sink.Flush(ctx))
+
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, requests[0], requests[1])
+	}
+}
+
+func TestElasticsearchSinkRequeue(t *testing.T) {
+	var mu sync.Mutex
+	available := false
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	sink := NewElasticsearchSink(server.URL)
+	sink.MaxRetries = 1
+	sink.RetryBackoff = 0
+
+	ctx := context.Background()
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, newTestInsert(1, 10, "tom", "tom@example.com"))))
+	assert.Error(t, sink.Flush(ctx))
+	assert.Equal(t, 2, requests)
+
+	// 重试失败的操作放回了缓冲, 恢复后再次提交
+	mu.Lock()
+	available = true
+	mu.Unlock()
+	assert.NoError(t, sink.Flush(ctx))
+	assert.Equal(t, 3, requests)
+	assert.NoError(t, sink.Flush(ctx))
+	assert.Equal(t, 3, requests)
+}
+
+func TestElasticsearchSinkMixedItems(t *testing.T) {
+	var mu sync.Mutex
+	requests := make([][]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lines := make([]string, 0)
+		scanner := bufio.NewScanner(req.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+
+		mu.Lock()
+		requests = append(requests, lines)
+		attempt := len(requests)
+		mu.Unlock()
+
+		if attempt == 1 {
+			// 删除不存在的文档返回404, 第二个操作返回429, 第三个操作被拒绝
+			w.Write([]byte(`{"errors":true,"items":[{"delete":{"status":404}},{"index":{"status":429}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	deadLetter, err := NewDeadLetterWriter(dir, 0, 0)
+	assert.NoError(t, err)
+	defer deadLetter.Close()
+
+	sink := NewElasticsearchSink(server.URL)
+	sink.RetryBackoff = 0
+	sink.DeadLetter = deadLetter
+
+	remove := newTestInsert(1, 10, "", "")
+	remove.operation = OPERATION_DELETE
+	remove.before = []interface{}{int64(10), "tom", "tom@example.com"}
+	remove.after = nil
+
+	ctx := context.Background()
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, remove)))
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, newTestInsert(2, 20, "jerry", "jerry@example.com"))))
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, newTestInsert(3, 30, "spike", "spike@example.com"))))
+	assert.NoError(t, sink.Flush(ctx))
+
+	// 只重试返回429的操作
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, []string{`{"index":{"_id":"20","_index":"shop.user"}}`}, requests[1][:1])
+		assert.Len(t, requests[1], 2)
+	}
+
+	letters := readTestDeadLetters(t, dir)
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, "shop.user", letters[0].Topic)
+		assert.Equal(t, DEAD_LETTER_KIND_ELASTICSEARCH, letters[0].Kind)
+		assert.Contains(t, letters[0].Error, "mapper_parsing_exception")
+		assert.Contains(t, string(letters[0].Data), `"_id":"30"`)
+	}
+}
+
+func TestElasticsearchSinkRequestTooLarge(t *testing.T) {
+	var mu sync.Mutex
+	sizes := make([]int, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		actions := 0
+		scanner := bufio.NewScanner(req.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), `{"index"`) {
+				actions++
+			}
+		}
+
+		mu.Lock()
+		sizes = append(sizes, actions)
+		mu.Unlock()
+
+		// 一次最多接受两个操作
+		if actions > 2 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	sink := NewElasticsearchSink(server.URL)
+	sink.RetryBackoff = 0
+
+	ctx := context.Background()
+	for i := int64(1); i <= 4; i++ {
+		assert.NoError(t, sink.Write(ctx, parseTestMessage(t, newTestInsert(i, i, "tom", "tom@example.com"))))
+	}
+	assert.NoError(t, sink.Flush(ctx))
+	assert.Equal(t, []int{4, 2, 2}, sizes)
+
+	// 单个操作仍然太大时被拒绝, 不会无限拆分
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	})
+	assert.NoError(t, sink.Write(ctx, parseTestMessage(t, newTestInsert(5, 5, "huge", "huge@example.com"))))
+	err := sink.Flush(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "status: 413")
+	}
+	assert.NoError(t, sink.Flush(ctx))
+}
@@ -0,0 +1,120 @@
+package alidts
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ChangeEvent 记录的扁平化表示, 用于webhook和归档等需要输出到外部的场景
+// 镜像中的值为nil表示NULL
+type ChangeEvent struct {
+	Id              int64              `json:"id"`
+	Version         int                `json:"version"`
+	SourceTimeStamp int64              `json:"sourceTimestamp"`
+	SourceTxId      string             `json:"sourceTxId,omitempty"`
+	Operation       string             `json:"operation"`
+	Database        string             `json:"database,omitempty"`
+	Table           string             `json:"table,omitempty"`
+	Fields          []*DtsField        `json:"fields,omitempty"`
+	Tags            map[string]string  `json:"tags,omitempty"`
+	Before          map[string]*string `json:"before,omitempty"`
+	After           map[string]*string `json:"after,omitempty"`
+}
+
+// NewChangeEvent 将记录转换成ChangeEvent
+func NewChangeEvent(r *DtsRecord) *ChangeEvent {
+	return &ChangeEvent{
+		Id:              r.Id,
+		Version:         r.Version,
+		SourceTimeStamp: r.SourceTimeStamp,
+		SourceTxId:      r.SourceTxId,
+		Operation:       r.Operation,
+		Database:        r.Database,
+		Table:           r.Table,
+		Fields:          r.loadTableFields(),
+		Tags:            r.Tags,
+		Before:          r.getNullableColumns(r.BeforeImages),
+		After:           r.getNullableColumns(r.AfterImages),
+	}
+}
+
+// Record 将ChangeEvent还原成记录, 镜像中的值按照字段类型重新编码
+// 无法按类型编码的值(例如日期时间)保存为Character, 通过GetAfterColumns等方法获取到的值不变
+func (e *ChangeEvent) Record() (*DtsRecord, error) {
+	r := &DtsRecord{
+		Version:         e.Version,
+		Id:              e.Id,
+		SourceTimeStamp: e.SourceTimeStamp,
+		SourceTxId:      e.SourceTxId,
+		Operation:       e.Operation,
+		Tags:            e.Tags,
+	}
+	if e.Database != "" {
+		name := e.Database
+		if e.Table != "" {
+			name += "." + e.Table
+		}
+		r.ObjectName = map[string]string{"string": name}
+	}
+	r.setTableFields(e.Fields)
+
+	var err error
+	r.BeforeImages, err = newImages(e.Fields, e.Before)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode before image, id: %d", e.Id)
+	}
+	r.AfterImages, err = newImages(e.Fields, e.After)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode after image, id: %d", e.Id)
+	}
+
+	return r, r.parse()
+}
+
+// newImages 按照字段列表将列值编码成镜像, cols为nil表示镜像为null
+func newImages(fields []*DtsField, cols map[string]*string) (map[string]interface{}, error) {
+	if cols == nil {
+		return nil, nil
+	}
+
+	array := make([]interface{}, len(fields))
+	for i, field := range fields {
+		value, ok := cols[field.Name]
+		if !ok {
+			return nil, errors.Errorf("missing column: %s", field.Name)
+		}
+		if value == nil {
+			continue
+		}
+
+		v, err := newImageValue(field.DataType, *value)
+		if err != nil {
+			v = newCharacterValue(*value)
+		}
+		array[i] = v
+	}
+	return map[string]interface{}{"array": array}, nil
+}
+
+// getNullableColumns 和getColumns相同, 但是NULL值用nil表示
+func (r *DtsRecord) getNullableColumns(images map[string]interface{}) map[string]*string {
+	array, ok := getImageArray(images)
+	if !ok || len(r.loadTableFields()) != len(array) {
+		return nil
+	}
+
+	cols := make(map[string]*string, len(array))
+	for index, v := range array {
+		field := r.TableFields[index]
+		if field == nil {
+			continue
+		}
+
+		if v == nil {
+			cols[field.Name] = nil
+			continue
+		}
+		value := r.getColValue(v)
+		cols[field.Name] = &value
+	}
+	return cols
+}
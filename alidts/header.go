@@ -0,0 +1,108 @@
+package alidts
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DtsHeader 记录的头部信息, 不包含字段定义和镜像
+type DtsHeader struct {
+	Version         int
+	Id              int64
+	SourceTimeStamp int64
+	SourceTxId      string
+	Operation       string
+	ObjectName      string // 数据库名.表名
+	Database        string
+	Table           string
+	Phase           Phase
+}
+
+// GetFullTableName 获取"数据库名.表名"形式的完整表名
+func (h *DtsHeader) GetFullTableName() string {
+	if h.Table == "" {
+		return h.Database
+	}
+	return h.Database + "." + h.Table
+}
+
+// LazyRecord 只解析了头部的记录, 需要时再解析完整的记录
+type LazyRecord struct {
+	DtsHeader
+	data   []byte
+	ad     *AliDts
+	once   sync.Once
+	record *DtsRecord
+	err    error
+}
+
+// ParseHeader 只解析记录的头部: version, id, 时间戳, 事务id, 操作类型和objectName
+// 头部位于消息的最前面, 解析时不需要解码字段定义和镜像, 适合在完整解析前按表过滤消息
+func (ad *AliDts) ParseHeader(data []byte) (*DtsHeader, error) {
+	schema, err := ad.lookupSchema(data)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := ad.acquireReader(data)
+	defer ad.readerPool.Put(reader)
+
+	h := &DtsHeader{}
+	for _, field := range schema.fields {
+		v := reader.ReadNext(field.Type())
+		if reader.Error != nil {
+			return nil, errors.Wrapf(reader.Error, "read field %s", field.Name())
+		}
+
+		switch field.Name() {
+		case "version":
+			h.Version, _ = v.(int)
+		case "id":
+			h.Id, _ = v.(int64)
+		case "sourceTimestamp":
+			h.SourceTimeStamp, _ = v.(int64)
+		case "sourceTxid":
+			h.SourceTxId, _ = v.(string)
+		case "operation":
+			h.Operation, _ = v.(string)
+		case "objectName":
+			if union, ok := v.(map[string]interface{}); ok {
+				h.ObjectName, _ = union["string"].(string)
+			}
+		}
+
+		// objectName是最后一个头部字段
+		if field.Name() == "objectName" {
+			break
+		}
+	}
+
+	h.Phase = getPhase(h.Operation)
+	h.Database, h.Table = splitObjectName(h.ObjectName)
+	return h, nil
+}
+
+// ParseLazy 解析记录的头部, 完整的记录在第一次调用Record时才解析
+// LazyRecord会持有data, 调用方在Record返回前不能修改data
+func (ad *AliDts) ParseLazy(data []byte) (*LazyRecord, error) {
+	h, err := ad.ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LazyRecord{
+		DtsHeader: *h,
+		data:      data,
+		ad:        ad,
+	}, nil
+}
+
+// Record 解析完整的记录, 多次调用只会解析一次
+func (l *LazyRecord) Record() (*DtsRecord, error) {
+	l.once.Do(func() {
+		l.record, l.err = l.ad.Parse(l.data)
+		l.data = nil
+	})
+	return l.record, l.err
+}
@@ -0,0 +1,42 @@
+package alidts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeader(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	data := newTestInsert(3, 10, "tom", "tom@example.com").encode()
+	h, err := ad.ParseHeader(data)
+	assert.NoError(t, err)
+	assert.Equal(t, &DtsHeader{
+		Id:              3,
+		SourceTimeStamp: 1600000003,
+		SourceTxId:      "tx3",
+		Operation:       OPERATION_INSERT,
+		ObjectName:      "shop.user",
+		Database:        "shop",
+		Table:           "user",
+		Phase:           PHASE_INCREMENTAL,
+	}, h)
+
+	_, err = ad.ParseHeader(data[:5])
+	assert.Error(t, err)
+}
+
+func TestParseLazy(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	lazy, err := ad.ParseLazy(newTestInsert(3, 10, "tom", "tom@example.com").encode())
+	assert.NoError(t, err)
+	assert.Equal(t, "shop.user", lazy.GetFullTableName())
+
+	r, err := lazy.Record()
+	assert.NoError(t, err)
+	assert.Equal(t, "tom", r.GetAfterColumns()["name"])
+}
@@ -0,0 +1,116 @@
+package alidts
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// avro中各种值类型的名称
+const (
+	AVRO_TYPE_INTEGER                 = "com.alibaba.alidts.formats.avro.Integer"
+	AVRO_TYPE_CHARACTER               = "com.alibaba.alidts.formats.avro.Character"
+	AVRO_TYPE_DECIMAL                 = "com.alibaba.alidts.formats.avro.Decimal"
+	AVRO_TYPE_FLOAT                   = "com.alibaba.alidts.formats.avro.Float"
+	AVRO_TYPE_TIMESTAMP               = "com.alibaba.alidts.formats.avro.Timestamp"
+	AVRO_TYPE_DATETIME                = "com.alibaba.alidts.formats.avro.DateTime"
+	AVRO_TYPE_TIMESTAMP_WITH_TIMEZONE = "com.alibaba.alidts.formats.avro.TimestampWithTimeZone"
+	AVRO_TYPE_BINARY_GEOMETRY         = "com.alibaba.alidts.formats.avro.BinaryGeometry"
+	AVRO_TYPE_TEXT_GEOMETRY           = "com.alibaba.alidts.formats.avro.TextGeometry"
+	AVRO_TYPE_BINARY_OBJECT           = "com.alibaba.alidts.formats.avro.BinaryObject"
+	AVRO_TYPE_TEXT_OBJECT             = "com.alibaba.alidts.formats.avro.TextObject"
+	AVRO_TYPE_EMPTY_OBJECT            = "com.alibaba.alidts.formats.avro.EmptyObject"
+)
+
+// newImageValue 按照mysql字段类型将字符串值编码成镜像中的avro值
+func newImageValue(dataType int, value string) (interface{}, error) {
+	switch dataType {
+	case MYSQL_TYPE_INT8, MYSQL_TYPE_INT16, MYSQL_TYPE_INT24, MYSQL_TYPE_INT32, MYSQL_TYPE_INT64,
+		MYSQL_TYPE_YEAR, MYSQL_TYPE_BIT:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+				return nil, errors.Errorf("invalid integer value: %q", value)
+			}
+		}
+		return map[string]interface{}{
+			AVRO_TYPE_INTEGER: map[string]interface{}{"precision": 0, "value": value},
+		}, nil
+	case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, errors.Errorf("invalid decimal value: %q", value)
+		}
+		return map[string]interface{}{
+			AVRO_TYPE_DECIMAL: map[string]interface{}{"value": value, "precision": 0, "scale": 0},
+		}, nil
+	case MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid float value: %q", value)
+		}
+		return map[string]interface{}{
+			AVRO_TYPE_FLOAT: map[string]interface{}{"value": f, "precision": 0, "scale": 0},
+		}, nil
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING, MYSQL_TYPE_JSON,
+		MYSQL_TYPE_ENUM, MYSQL_TYPE_SET,
+		MYSQL_TYPE_TINY_BLOB, MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB:
+		return newCharacterValue(value), nil
+	}
+	return nil, errors.Errorf("unsupported data type: %d", dataType)
+}
+
+// newCharacterValue 将字符串编码成镜像中的Character值
+func newCharacterValue(value string) interface{} {
+	return map[string]interface{}{
+		AVRO_TYPE_CHARACTER: map[string]interface{}{"charset": "utf8mb4", "value": []byte(value)},
+	}
+}
+
+// fieldIndex 获取字段在字段列表和镜像中的位置, 不存在返回-1
+func (r *DtsRecord) fieldIndex(name string) int {
+	for i, field := range r.loadTableFields() {
+		if field != nil && field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// setTableFields 修改字段列表, 同时更新原始的Fields, 保证两者一致
+func (r *DtsRecord) setTableFields(fields []*DtsField) {
+	items := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		items = append(items, map[string]interface{}{"name": field.Name, "dataTypeNumber": field.DataType})
+	}
+
+	r.TableFields = fields
+	r.Fields = map[string]interface{}{"array": items}
+}
+
+// eachImage 对改变前和改变后镜像中的值数组依次调用fn, fn返回新的值数组
+func (r *DtsRecord) eachImage(fn func(array []interface{}) ([]interface{}, error)) error {
+	for _, images := range []map[string]interface{}{r.BeforeImages, r.AfterImages} {
+		array, ok := getImageArray(images)
+		if !ok {
+			continue
+		}
+
+		newArray, err := fn(array)
+		if err != nil {
+			return err
+		}
+		images["array"] = newArray
+	}
+	return nil
+}
+
+// currentColumns 获取当前行的值, DELETE使用改变前镜像, 其他操作优先使用改变后镜像
+func (r *DtsRecord) currentColumns() map[string]string {
+	var cols map[string]string
+	if r.Operation != OPERATION_DELETE {
+		cols = r.GetAfterColumns()
+	}
+	if cols == nil {
+		cols = r.GetBeforeColumns()
+	}
+	return cols
+}
@@ -0,0 +1,203 @@
+package alidts
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Invalidator 根据每张表声明的key模板计算需要失效的缓存key
+// 模板中的{column}会被替换成列的值, 例如"user:{id}", "user:email:{email}"
+// 改变前和改变后的镜像都会参与计算, 这样修改唯一键时旧值对应的key也会被失效
+type Invalidator struct {
+	templates map[string][]string
+}
+
+// NewInvalidator 创建Invalidator, templates的key为"数据库名.表名"
+func NewInvalidator(templates map[string][]string) (*Invalidator, error) {
+	for table, tpls := range templates {
+		for _, tpl := range tpls {
+			err := checkTemplate(tpl)
+			if err != nil {
+				return nil, errors.Wrapf(err, "table: %s", table)
+			}
+		}
+	}
+
+	return &Invalidator{
+		templates: templates,
+	}, nil
+}
+
+// Keys 计算一条记录需要失效的key, 结果已经去重
+// 缺少模板中任意一列或者任意一列为NULL的镜像不会生成对应的key
+func (inv *Invalidator) Keys(r *DtsRecord) []string {
+	set := NewInvalidationSet()
+	inv.collect(set, r)
+	return set.Keys()
+}
+
+// Collect 计算多条记录需要失效的key, 一般用于一个事务中的所有记录
+func (inv *Invalidator) Collect(records ...*DtsRecord) []string {
+	set := NewInvalidationSet()
+	for _, r := range records {
+		inv.collect(set, r)
+	}
+	return set.Keys()
+}
+
+func (inv *Invalidator) collect(set *InvalidationSet, r *DtsRecord) {
+	if r == nil || r.Table == "" {
+		return
+	}
+
+	tpls := inv.templates[r.GetFullTableName()]
+	if len(tpls) == 0 {
+		return
+	}
+
+	for _, images := range []map[string]interface{}{r.BeforeImages, r.AfterImages} {
+		// NULL不能和空字符串混在一起, 否则会生成"user:email:"这样的key
+		cols := r.getNullableColumns(images)
+		if cols == nil {
+			continue
+		}
+
+		for _, tpl := range tpls {
+			key, ok := renderTemplate(tpl, func(name string) (string, bool) {
+				v, exist := cols[name]
+				if !exist || v == nil {
+					return "", false
+				}
+				return *v, true
+			})
+			if ok {
+				set.Add(key)
+			}
+		}
+	}
+}
+
+// InvalidationSet 保持加入顺序的去重key集合
+type InvalidationSet struct {
+	keys []string
+	seen map[string]struct{}
+}
+
+func NewInvalidationSet() *InvalidationSet {
+	return &InvalidationSet{
+		seen: make(map[string]struct{}),
+	}
+}
+
+// Add 加入key, 已经存在的key会被忽略
+func (s *InvalidationSet) Add(keys ...string) {
+	for _, key := range keys {
+		if _, exist := s.seen[key]; exist {
+			continue
+		}
+		s.seen[key] = struct{}{}
+		s.keys = append(s.keys, key)
+	}
+}
+
+// Keys 按照加入的顺序返回所有的key
+func (s *InvalidationSet) Keys() []string {
+	return s.keys
+}
+
+// Len key的数量
+func (s *InvalidationSet) Len() int {
+	return len(s.keys)
+}
+
+const txInvalidationDefaultMaxPending = 10000
+
+// TxInvalidationCollector 按事务收集需要失效的key, 事务提交时才输出, 回滚的事务会被丢弃
+// 未结束的事务超过MaxPending时淘汰最早的事务, 被淘汰事务的key随下一次Add一起返回,
+// 提前失效缓存比丢失失效更安全
+type TxInvalidationCollector struct {
+	mu          sync.Mutex
+	invalidator *Invalidator
+	pending     map[string]*list.Element // 事务id -> order中的元素
+	order       *list.List               // 按照开始顺序排列的未结束事务
+
+	// MaxPending 未结束事务的最大数量, <=0表示不限制
+	MaxPending int
+}
+
+// pendingTx 一个未结束的事务
+type pendingTx struct {
+	txId string
+	set  *InvalidationSet
+}
+
+func NewTxInvalidationCollector(invalidator *Invalidator) *TxInvalidationCollector {
+	return &TxInvalidationCollector{
+		invalidator: invalidator,
+		pending:     make(map[string]*list.Element),
+		order:       list.New(),
+		MaxPending:  txInvalidationDefaultMaxPending,
+	}
+}
+
+// Add 加入一条记录, 收到COMMIT时返回该事务需要失效的key和true
+// 没有事务id的记录会立即返回自己需要失效的key
+func (c *TxInvalidationCollector) Add(r *DtsRecord) ([]string, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	if r.SourceTxId == "" {
+		keys := c.invalidator.Keys(r)
+		return keys, len(keys) > 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch r.Operation {
+	case OPERATION_COMMIT:
+		tx := c.remove(r.SourceTxId)
+		if tx == nil || tx.set.Len() == 0 {
+			return nil, false
+		}
+		return tx.set.Keys(), true
+	case OPERATION_ROLLBACK, OPERATION_ABORT:
+		c.remove(r.SourceTxId)
+		return nil, false
+	}
+
+	elem, exist := c.pending[r.SourceTxId]
+	if !exist {
+		elem = c.order.PushBack(&pendingTx{txId: r.SourceTxId, set: NewInvalidationSet()})
+		c.pending[r.SourceTxId] = elem
+	}
+	c.invalidator.collect(elem.Value.(*pendingTx).set, r)
+
+	evicted := NewInvalidationSet()
+	for c.MaxPending > 0 && c.order.Len() > c.MaxPending {
+		tx := c.remove(c.order.Front().Value.(*pendingTx).txId)
+		evicted.Add(tx.set.Keys()...)
+	}
+	return evicted.Keys(), evicted.Len() > 0
+}
+
+// Pending 未结束事务的数量
+func (c *TxInvalidationCollector) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// remove 删除一个未结束的事务, 调用时需要持有锁
+func (c *TxInvalidationCollector) remove(txId string) *pendingTx {
+	elem, exist := c.pending[txId]
+	if !exist {
+		return nil
+	}
+	delete(c.pending, txId)
+	return c.order.Remove(elem).(*pendingTx)
+}
@@ -0,0 +1,134 @@
+package alidts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestInvalidator(t *testing.T) *Invalidator {
+	inv, err := NewInvalidator(map[string][]string{
+		"shop.user": {"user:{id}", "user:email:{email}"},
+	})
+	assert.NoError(t, err)
+	return inv
+}
+
+func newTestTxMessage(txId, operation string) testMessage {
+	return testMessage{txId: txId, operation: operation}
+}
+
+func TestNewInvalidator(t *testing.T) {
+	_, err := NewInvalidator(map[string][]string{"shop.user": {"user:{id"}})
+	assert.Error(t, err)
+
+	_, err = NewInvalidator(map[string][]string{"shop.user": {"user:id}"}})
+	assert.Error(t, err)
+}
+
+func TestInvalidatorKeys(t *testing.T) {
+	inv := newTestInvalidator(t)
+
+	r := parseTestMessage(t, newTestInsert(1, 10, "tom", "tom@example.com"))
+	assert.Equal(t, []string{"user:10", "user:email:tom@example.com"}, inv.Keys(r))
+
+	// 没有模板的表不生成key
+	other := newTestInsert(2, 10, "tom", "tom@example.com")
+	other.objectName = "shop.order"
+	assert.Len(t, inv.Keys(parseTestMessage(t, other)), 0)
+
+	// NULL不能渲染成空字符串
+	null := newTestInsert(3, 10, "tom", "")
+	null.after = []interface{}{int64(10), "tom", nil}
+	assert.Equal(t, []string{"user:10"}, inv.Keys(parseTestMessage(t, null)))
+
+	// 空字符串不是NULL
+	empty := newTestInsert(4, 10, "tom", "")
+	assert.Equal(t, []string{"user:10", "user:email:"}, inv.Keys(parseTestMessage(t, empty)))
+}
+
+func TestInvalidatorKeysUpdate(t *testing.T) {
+	inv := newTestInvalidator(t)
+
+	// 修改唯一键时旧值和新值对应的key都要失效
+	rename := newTestInsert(1, 10, "tom", "tommy@example.com")
+	rename.operation = OPERATION_UPDATE
+	rename.before = []interface{}{int64(10), "tom", "tom@example.com"}
+	assert.Equal(t, []string{"user:10", "user:email:tom@example.com", "user:email:tommy@example.com"},
+		inv.Keys(parseTestMessage(t, rename)))
+
+	// 从NULL改为有值时只有新值对应的key
+	fill := newTestInsert(2, 10, "tom", "tom@example.com")
+	fill.operation = OPERATION_UPDATE
+	fill.before = []interface{}{int64(10), "tom", nil}
+	assert.Equal(t, []string{"user:10", "user:email:tom@example.com"}, inv.Keys(parseTestMessage(t, fill)))
+
+	records := []*DtsRecord{
+		parseTestMessage(t, newTestInsert(3, 10, "tom", "tom@example.com")),
+		parseTestMessage(t, newTestInsert(4, 20, "jerry", "tom@example.com")),
+	}
+	assert.Equal(t, []string{"user:10", "user:email:tom@example.com", "user:20"}, inv.Collect(records...))
+}
+
+func TestTxInvalidationCollector(t *testing.T) {
+	c := NewTxInvalidationCollector(newTestInvalidator(t))
+
+	first := newTestInsert(1, 10, "tom", "tom@example.com")
+	first.txId = "tx1"
+	second := newTestInsert(2, 20, "jerry", "jerry@example.com")
+	second.txId = "tx1"
+	rolledBack := newTestInsert(3, 30, "spike", "spike@example.com")
+	rolledBack.txId = "tx2"
+
+	for _, m := range []testMessage{first, second, rolledBack} {
+		keys, ok := c.Add(parseTestMessage(t, m))
+		assert.False(t, ok)
+		assert.Len(t, keys, 0)
+	}
+	assert.Equal(t, 2, c.Pending())
+
+	keys, ok := c.Add(parseTestMessage(t, newTestTxMessage("tx2", OPERATION_ROLLBACK)))
+	assert.False(t, ok)
+	assert.Len(t, keys, 0)
+
+	keys, ok = c.Add(parseTestMessage(t, newTestTxMessage("tx1", OPERATION_COMMIT)))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"user:10", "user:email:tom@example.com", "user:20", "user:email:jerry@example.com"}, keys)
+	assert.Equal(t, 0, c.Pending())
+
+	// 没有收集到key的事务提交时不输出
+	_, ok = c.Add(parseTestMessage(t, newTestTxMessage("tx3", OPERATION_COMMIT)))
+	assert.False(t, ok)
+
+	// 没有事务id的记录立即输出
+	noTx := newTestInsert(4, 40, "tyke", "tyke@example.com")
+	noTx.txId = ""
+	keys, ok = c.Add(parseTestMessage(t, noTx))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"user:40", "user:email:tyke@example.com"}, keys)
+}
+
+func TestTxInvalidationCollectorMaxPending(t *testing.T) {
+	c := NewTxInvalidationCollector(newTestInvalidator(t))
+	c.MaxPending = 2
+
+	_, ok := c.Add(parseTestMessage(t, newTestInsert(1, 10, "tom", "tom@example.com")))
+	assert.False(t, ok)
+	_, ok = c.Add(parseTestMessage(t, newTestInsert(2, 20, "jerry", "jerry@example.com")))
+	assert.False(t, ok)
+
+	// 超过MaxPending时最早的事务被淘汰, 它的key立即输出
+	keys, ok := c.Add(parseTestMessage(t, newTestInsert(3, 30, "spike", "spike@example.com")))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"user:10", "user:email:tom@example.com"}, keys)
+	assert.Equal(t, 2, c.Pending())
+
+	// 被淘汰的事务提交时不再输出
+	_, ok = c.Add(parseTestMessage(t, newTestTxMessage("tx1", OPERATION_COMMIT)))
+	assert.False(t, ok)
+
+	keys, ok = c.Add(parseTestMessage(t, newTestTxMessage("tx2", OPERATION_COMMIT)))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"user:20", "user:email:jerry@example.com"}, keys)
+	assert.Equal(t, 1, c.Pending())
+}
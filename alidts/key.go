@@ -0,0 +1,90 @@
+package alidts
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// KEY_SEPARATOR 多列组成行唯一键时的分隔符
+const KEY_SEPARATOR = ":"
+
+// KeyFunc 计算记录对应数据行的唯一键, 无法计算时返回空字符串
+type KeyFunc func(r *DtsRecord) string
+
+// PrimaryKey 缺省的KeyFunc, 使用tags中pk_uk_info声明的主键计算行的唯一键
+func PrimaryKey(r *DtsRecord) string {
+	return r.GetKey(r.GetPrimaryKeyNames()...)
+}
+
+// KeyColumns 按表指定主键列的KeyFunc, tables的key为"数据库名.表名"
+// 没有配置的表退回到PrimaryKey
+func KeyColumns(tables map[string][]string) KeyFunc {
+	return func(r *DtsRecord) string {
+		if columns, exist := tables[r.GetFullTableName()]; exist {
+			return r.GetKey(columns...)
+		}
+		return PrimaryKey(r)
+	}
+}
+
+// GetPrimaryKeyNames 从tags的pk_uk_info中获取主键列名, 没有主键时使用第一个唯一键
+// pk_uk_info的格式为: {"PRIMARY":["id"],"uk_email":["email"]}
+func (r *DtsRecord) GetPrimaryKeyNames() []string {
+	info := r.Tags["pk_uk_info"]
+	if info == "" {
+		return nil
+	}
+
+	var keys map[string][]string
+	err := json.Unmarshal([]byte(info), &keys)
+	if err != nil {
+		return nil
+	}
+
+	if columns, exist := keys["PRIMARY"]; exist {
+		return columns
+	}
+
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		return keys[names[0]]
+	}
+	return nil
+}
+
+// GetBeforeKey 用keyFunc按照改变前镜像计算唯一键, UPDATE修改了唯一键时为旧的唯一键
+// 没有改变前镜像时返回空字符串
+func (r *DtsRecord) GetBeforeKey(keyFunc KeyFunc) string {
+	// keyFunc通过GetKey选择镜像, 按DELETE计算即使用改变前镜像
+	before := *r
+	before.Operation = OPERATION_DELETE
+	return keyFunc(&before)
+}
+
+// GetKey 用指定列的值拼接成行的唯一键
+// DELETE使用改变前镜像, 其他操作优先使用改变后镜像
+func (r *DtsRecord) GetKey(columns ...string) string {
+	if len(columns) == 0 {
+		return ""
+	}
+
+	cols := r.currentColumns()
+	if cols == nil {
+		return ""
+	}
+
+	values := make([]string, 0, len(columns))
+	for _, column := range columns {
+		v, exist := cols[column]
+		if !exist {
+			return ""
+		}
+		values = append(values, v)
+	}
+	return strings.Join(values, KEY_SEPARATOR)
+}
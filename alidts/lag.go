@@ -0,0 +1,160 @@
+package alidts
+
+import (
+	"sync"
+	"time"
+)
+
+// TablePosition 某张表最后一次收到的记录位置
+type TablePosition struct {
+	Id              int64
+	SourceTimeStamp int64
+	SourceTxId      string
+	Operation       string
+	ReceivedAt      time.Time
+}
+
+// LagTracker 根据记录的源库时间戳和DTS处理时间戳计算复制延迟
+// HEARTBEAT和CHECKPOINT记录同样参与计算, 从而在源库没有写入的时候也能得到准确的延迟
+type LagTracker struct {
+	mu               sync.RWMutex
+	now              func() time.Time
+	lastSourceTime   time.Time     // 最近一次收到的源库时间
+	lastProcessTime  time.Time     // 最近一次收到的DTS处理时间
+	lastReceivedTime time.Time     // 最近一次收到记录的本地时间
+	currentLag       time.Duration // 最近一次收到记录时的延迟
+	maxLag           time.Duration
+	positions        map[string]*TablePosition
+}
+
+func NewLagTracker() *LagTracker {
+	return &LagTracker{
+		now:       time.Now,
+		positions: make(map[string]*TablePosition),
+	}
+}
+
+// Track 消费一条记录并更新延迟信息, 所有收到的记录都应该交给Track
+func (t *LagTracker) Track(r *DtsRecord) {
+	if r == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.lastReceivedTime = now
+
+	if r.SourceTimeStamp > 0 {
+		sourceTime := r.GetSourceTime()
+		if sourceTime.After(t.lastSourceTime) {
+			t.lastSourceTime = sourceTime
+		}
+
+		t.currentLag = now.Sub(sourceTime)
+		if t.currentLag < 0 {
+			t.currentLag = 0
+		}
+		if t.currentLag > t.maxLag {
+			t.maxLag = t.currentLag
+		}
+	}
+
+	if timestamps := r.GetProcessTimestamps(); len(timestamps) > 0 {
+		processTime := time.Unix(0, timestamps[len(timestamps)-1]*int64(time.Millisecond))
+		if processTime.After(t.lastProcessTime) {
+			t.lastProcessTime = processTime
+		}
+	}
+
+	// 只有带表名的记录才记录表的位置
+	if r.Table == "" {
+		return
+	}
+
+	name := r.GetFullTableName()
+	pos, exist := t.positions[name]
+	if !exist {
+		pos = &TablePosition{}
+		t.positions[name] = pos
+	}
+	pos.Id = r.Id
+	pos.SourceTimeStamp = r.SourceTimeStamp
+	pos.SourceTxId = r.SourceTxId
+	pos.Operation = r.Operation
+	pos.ReceivedAt = now
+}
+
+// CurrentLag 当前的复制延迟
+// 如果一直没有收到新的记录, 延迟会随着时间增长, 这样DTS停止投递时也能触发告警
+func (t *LagTracker) CurrentLag() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.lastSourceTime.IsZero() {
+		return 0
+	}
+
+	// 在收到记录时的延迟基础上加上之后没有收到记录的时长
+	return t.currentLag + t.now().Sub(t.lastReceivedTime)
+}
+
+// DeliveryLag DTS最后一个处理环节到当前的延迟, 即DTS投递到消费者的耗时
+func (t *LagTracker) DeliveryLag() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.lastProcessTime.IsZero() {
+		return 0
+	}
+
+	lag := t.now().Sub(t.lastProcessTime)
+	if lag < 0 {
+		return 0
+	}
+	return lag
+}
+
+// MaxLag 收到记录时出现过的最大延迟
+func (t *LagTracker) MaxLag() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.maxLag
+}
+
+// ResetMaxLag 重置最大延迟, 一般在每个告警周期结束后调用
+func (t *LagTracker) ResetMaxLag() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxLag = 0
+}
+
+// IsLagging 当前延迟是否超过了阈值
+func (t *LagTracker) IsLagging(threshold time.Duration) bool {
+	return t.CurrentLag() > threshold
+}
+
+// Position 获取某张表最后收到的记录位置
+func (t *LagTracker) Position(database, table string) (TablePosition, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	pos, exist := t.positions[database+"."+table]
+	if !exist {
+		return TablePosition{}, false
+	}
+	return *pos, true
+}
+
+// Positions 获取所有表最后收到的记录位置, key为"数据库名.表名"
+func (t *LagTracker) Positions() map[string]TablePosition {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	positions := make(map[string]TablePosition, len(t.positions))
+	for name, pos := range t.positions {
+		positions[name] = *pos
+	}
+	return positions
+}
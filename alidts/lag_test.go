@@ -0,0 +1,76 @@
+package alidts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLagTracker(t *testing.T) {
+	now := time.Unix(1600000010, 0)
+	tracker := NewLagTracker()
+	tracker.now = func() time.Time { return now }
+
+	// 还没有收到记录时没有延迟
+	assert.Equal(t, time.Duration(0), tracker.CurrentLag())
+	assert.Equal(t, time.Duration(0), tracker.DeliveryLag())
+
+	m := newTestInsert(1, 10, "tom", "tom@example.com")
+	m.processTimestamps = []int64{1600000002000, 1600000008500}
+	tracker.Track(parseTestMessage(t, m))
+	assert.Equal(t, 9*time.Second, tracker.CurrentLag())
+	assert.Equal(t, 9*time.Second, tracker.MaxLag())
+	assert.Equal(t, 1500*time.Millisecond, tracker.DeliveryLag())
+
+	// 没有收到新的记录时延迟随着时间增长
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, 14*time.Second, tracker.CurrentLag())
+	assert.Equal(t, 6500*time.Millisecond, tracker.DeliveryLag())
+	assert.True(t, tracker.IsLagging(10*time.Second))
+	assert.Equal(t, 9*time.Second, tracker.MaxLag())
+
+	// 心跳同样可以更新延迟, 但是不记录表的位置
+	tracker.Track(parseTestMessage(t, testMessage{id: 2, sourceTimestamp: 1600000014, operation: OPERATION_HEARTBEAT}))
+	assert.Equal(t, time.Second, tracker.CurrentLag())
+	assert.False(t, tracker.IsLagging(10*time.Second))
+	assert.Equal(t, 9*time.Second, tracker.MaxLag())
+
+	tracker.ResetMaxLag()
+	assert.Equal(t, time.Duration(0), tracker.MaxLag())
+
+	// 源库时间晚于本地时间时延迟为0
+	tracker.Track(parseTestMessage(t, testMessage{id: 3, sourceTimestamp: 1600000020, operation: OPERATION_HEARTBEAT}))
+	assert.Equal(t, time.Duration(0), tracker.CurrentLag())
+	assert.Equal(t, time.Duration(0), tracker.MaxLag())
+}
+
+func TestLagTrackerPositions(t *testing.T) {
+	now := time.Unix(1600000010, 0)
+	tracker := NewLagTracker()
+	tracker.now = func() time.Time { return now }
+
+	order := newTestInsert(2, 20, "jerry", "jerry@example.com")
+	order.objectName = "shop.order"
+	for _, m := range []testMessage{newTestInsert(1, 10, "tom", "tom@example.com"), order, newTestInsert(3, 30, "spike", "spike@example.com")} {
+		tracker.Track(parseTestMessage(t, m))
+	}
+	tracker.Track(parseTestMessage(t, testMessage{id: 4, txId: "tx3", operation: OPERATION_COMMIT}))
+
+	pos, exist := tracker.Position("shop", "user")
+	assert.True(t, exist)
+	assert.Equal(t, TablePosition{
+		Id:              3,
+		SourceTimeStamp: 1600000003,
+		SourceTxId:      "tx3",
+		Operation:       OPERATION_INSERT,
+		ReceivedAt:      now,
+	}, pos)
+
+	_, exist = tracker.Position("shop", "product")
+	assert.False(t, exist)
+
+	positions := tracker.Positions()
+	assert.Len(t, positions, 2)
+	assert.Equal(t, int64(2), positions["shop.order"].Id)
+}
@@ -0,0 +1,216 @@
+package alidts
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"utils"
+)
+
+// 脱敏策略
+const (
+	MASK_STRATEGY_REDACT    = "redact"    // 整体替换成REDACTED_VALUE
+	MASK_STRATEGY_KEEP_LAST = "keep_last" // 只保留最后N个字符
+	MASK_STRATEGY_PARTIAL   = "partial"   // 保留前后若干字符
+	MASK_STRATEGY_HASH      = "hash"      // 加盐的sha256
+)
+
+var (
+	rxMobilePhone = regexp.MustCompile(`^(\+?86)?1[3-9]\d{9}$`)
+	rxIdCard      = regexp.MustCompile(`^\d{17}[\dXx]$`)
+)
+
+// 敏感值的识别函数
+var sensitiveDetectors = map[string]func(value string) bool{
+	"email":  utils.IsEmail,
+	"phone":  rxMobilePhone.MatchString,
+	"idcard": rxIdCard.MatchString,
+}
+
+// MaskingRule 按列匹配的脱敏规则
+// Columns中的每一项可以是列名的通配符, 例如"*phone*", 也可以是"数据库名.表名.列名"的通配符, 例如"shop.user.email"
+type MaskingRule struct {
+	Columns  []string `json:"columns" yaml:"columns"`
+	Strategy string   `json:"strategy" yaml:"strategy"`
+	KeepLast int      `json:"keep_last,omitempty" yaml:"keep_last,omitempty"`
+	Salt     string   `json:"salt,omitempty" yaml:"salt,omitempty"`
+}
+
+// DetectRule 根据值识别敏感信息的规则, 用于列名无法预知的场景
+// Types为需要识别的类型: email, phone, idcard, 为空时识别所有类型
+type DetectRule struct {
+	Types    []string `json:"types,omitempty" yaml:"types,omitempty"`
+	Strategy string   `json:"strategy" yaml:"strategy"`
+	KeepLast int      `json:"keep_last,omitempty" yaml:"keep_last,omitempty"`
+	Salt     string   `json:"salt,omitempty" yaml:"salt,omitempty"`
+}
+
+// MaskingPolicyConfig 脱敏策略的配置
+type MaskingPolicyConfig struct {
+	Rules  []MaskingRule `json:"rules" yaml:"rules"`
+	Detect *DetectRule   `json:"detect,omitempty" yaml:"detect,omitempty"`
+}
+
+// MaskingPolicy 对改变前和改变后镜像中的敏感信息脱敏
+// 先按列名匹配规则, 没有匹配到规则的字符串列再根据值识别敏感信息
+type MaskingPolicy struct {
+	rules     []maskingRule
+	detectors []func(value string) bool
+	detected  Masker
+}
+
+type maskingRule struct {
+	patterns []string
+	masker   Masker
+}
+
+// NewMaskingPolicy 根据配置创建脱敏策略
+func NewMaskingPolicy(config MaskingPolicyConfig) (*MaskingPolicy, error) {
+	p := &MaskingPolicy{}
+
+	for i, rule := range config.Rules {
+		masker, err := newMasker(rule.Strategy, rule.Salt, 0, rule.KeepLast)
+		if err != nil {
+			return nil, errors.Wrapf(err, "masking rule: %d", i)
+		}
+
+		patterns := make([]string, 0, len(rule.Columns))
+		for _, pattern := range rule.Columns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.Wrapf(err, "masking rule: %d, pattern: %s", i, pattern)
+			}
+			patterns = append(patterns, strings.ToLower(pattern))
+		}
+		p.rules = append(p.rules, maskingRule{patterns: patterns, masker: masker})
+	}
+
+	if config.Detect != nil {
+		masker, err := newMasker(config.Detect.Strategy, config.Detect.Salt, 0, config.Detect.KeepLast)
+		if err != nil {
+			return nil, errors.Wrap(err, "detect rule")
+		}
+		p.detected = masker
+
+		types := config.Detect.Types
+		if len(types) == 0 {
+			types = []string{"email", "phone", "idcard"}
+		}
+		for _, t := range types {
+			detector, exist := sensitiveDetectors[t]
+			if !exist {
+				return nil, errors.Errorf("unsupported detect type: %s", t)
+			}
+			p.detectors = append(p.detectors, detector)
+		}
+	}
+
+	return p, nil
+}
+
+// LoadMaskingPolicy 从yaml或者json文件中加载脱敏策略
+func LoadMaskingPolicy(path string) (*MaskingPolicy, error) {
+	var config MaskingPolicyConfig
+	err := decodeRulesFile(path, &config)
+	if err != nil {
+		return nil, errors.Wrap(err, "load masking policy")
+	}
+	return NewMaskingPolicy(config)
+}
+
+// Transform 将脱敏策略转换成Transform, 可以加入到Transformer中
+func (p *MaskingPolicy) Transform() Transform {
+	return func(r *DtsRecord) (bool, error) {
+		p.Apply(r)
+		return true, nil
+	}
+}
+
+// Apply 对记录做脱敏
+func (p *MaskingPolicy) Apply(r *DtsRecord) {
+	fields := r.loadTableFields()
+	for index, field := range fields {
+		if field == nil {
+			continue
+		}
+
+		if masker := p.match(r, field.Name); masker != nil {
+			r.maskValue(index, masker)
+			continue
+		}
+
+		if len(p.detectors) == 0 || !isStringType(field.DataType) {
+			continue
+		}
+
+		// 根据值识别, 同一列在改变前和改变后镜像中任意一个被识别为敏感信息, 整列都需要脱敏
+		if p.detect(r, index) {
+			r.maskValue(index, p.detected)
+		}
+	}
+}
+
+// match 查找列匹配的脱敏规则
+func (p *MaskingPolicy) match(r *DtsRecord, column string) Masker {
+	column = strings.ToLower(column)
+	fullName := strings.ToLower(r.GetFullTableName()) + "." + column
+	for _, rule := range p.rules {
+		for _, pattern := range rule.patterns {
+			name := column
+			if strings.Count(pattern, ".") == 2 {
+				name = fullName
+			}
+			if matched, _ := path.Match(pattern, name); matched {
+				return rule.masker
+			}
+		}
+	}
+	return nil
+}
+
+func (p *MaskingPolicy) detect(r *DtsRecord, index int) bool {
+	for _, images := range []map[string]interface{}{r.BeforeImages, r.AfterImages} {
+		array, ok := getImageArray(images)
+		if !ok || index >= len(array) || array[index] == nil {
+			continue
+		}
+
+		value := r.getColValue(array[index])
+		for _, detector := range p.detectors {
+			if value != "" && detector(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newMasker 根据脱敏策略创建Masker
+func newMasker(strategy, salt string, keepFirst, keepLast int) (Masker, error) {
+	switch strategy {
+	case MASK_STRATEGY_REDACT:
+		return RedactMasker(), nil
+	case MASK_STRATEGY_KEEP_LAST:
+		return KeepLastMasker(keepLast), nil
+	case MASK_STRATEGY_PARTIAL:
+		return PartialMasker(keepFirst, keepLast), nil
+	case MASK_STRATEGY_HASH:
+		// 没有盐的sha256可以通过字典反查出手机号等取值范围小的原值
+		if salt == "" {
+			return nil, errors.New("salt is required for hash mask strategy")
+		}
+		return HashMasker(salt), nil
+	}
+	return nil, errors.Errorf("unsupported mask strategy: %s", strategy)
+}
+
+// isStringType 是否是字符串类型的字段
+func isStringType(dataType int) bool {
+	switch dataType {
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING,
+		MYSQL_TYPE_TINY_BLOB, MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package alidts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMasker(t *testing.T) {
+	masker, err := newMasker(MASK_STRATEGY_REDACT, "", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, REDACTED_VALUE, masker("13812345678"))
+
+	masker, err = newMasker(MASK_STRATEGY_KEEP_LAST, "", 0, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "*******5678", masker("13812345678"))
+	assert.Equal(t, "***", masker("abc"))
+
+	masker, err = newMasker(MASK_STRATEGY_PARTIAL, "", 3, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "138****5678", masker("13812345678"))
+
+	masker, err = newMasker(MASK_STRATEGY_HASH, "s", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, HashMasker("s")("13812345678"), masker("13812345678"))
+	assert.NotEqual(t, HashMasker("t")("13812345678"), masker("13812345678"))
+	assert.Len(t, masker("13812345678"), 64)
+
+	// hash策略必须配置盐
+	_, err = newMasker(MASK_STRATEGY_HASH, "", 0, 0)
+	assert.Error(t, err)
+
+	_, err = newMasker("md5", "s", 0, 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported mask strategy: md5")
+	}
+	_, err = newMasker("", "", 0, 0)
+	assert.Error(t, err)
+}
+
+func TestNewMaskingPolicyInvalid(t *testing.T) {
+	_, err := NewMaskingPolicy(MaskingPolicyConfig{
+		Rules: []MaskingRule{{Columns: []string{"phone"}, Strategy: MASK_STRATEGY_HASH}},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "masking rule: 0")
+	}
+
+	_, err = NewMaskingPolicy(MaskingPolicyConfig{Detect: &DetectRule{Strategy: MASK_STRATEGY_HASH}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "detect rule")
+	}
+
+	_, err = NewMaskingPolicy(MaskingPolicyConfig{
+		Rules: []MaskingRule{{Columns: []string{"[phone"}, Strategy: MASK_STRATEGY_REDACT}},
+	})
+	assert.Error(t, err)
+
+	_, err = NewMaskingPolicy(MaskingPolicyConfig{Detect: &DetectRule{Types: []string{"address"}, Strategy: MASK_STRATEGY_REDACT}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported detect type: address")
+	}
+}
+
+func TestMaskingPolicyStrategies(t *testing.T) {
+	policy, err := NewMaskingPolicy(MaskingPolicyConfig{
+		Rules: []MaskingRule{
+			{Columns: []string{"NAME"}, Strategy: MASK_STRATEGY_HASH, Salt: "s"},
+			{Columns: []string{"shop.user.email"}, Strategy: MASK_STRATEGY_KEEP_LAST, KeepLast: 11},
+		},
+	})
+	assert.NoError(t, err)
+
+	update := newTestInsert(1, 10, "jerry", "jerry@example.com")
+	update.operation = OPERATION_UPDATE
+	update.before = []interface{}{int64(10), "tom", nil}
+	r := parseTestMessage(t, update)
+	policy.Apply(r)
+
+	// 列名匹配不区分大小写, 改变前和改变后镜像都要脱敏, NULL保持不变
+	assert.Equal(t, map[string]string{"id": "10", "name": HashMasker("s")("jerry"), "email": "******example.com"}, r.GetAfterColumns())
+	assert.Equal(t, HashMasker("s")("tom"), r.GetBeforeColumns()["name"])
+	assert.Nil(t, r.getNullableColumns(r.BeforeImages)["email"])
+
+	// 库表不匹配时不脱敏
+	other := newTestInsert(2, 20, "spike", "spike@example.com")
+	other.objectName = "shop.order"
+	r = parseTestMessage(t, other)
+	policy.Apply(r)
+	assert.Equal(t, "spike@example.com", r.GetAfterColumns()["email"])
+	assert.Equal(t, HashMasker("s")("spike"), r.GetAfterColumns()["name"])
+}
+
+func TestMaskingPolicyDetect(t *testing.T) {
+	policy, err := NewMaskingPolicy(MaskingPolicyConfig{
+		Detect: &DetectRule{Types: []string{"phone"}, Strategy: MASK_STRATEGY_KEEP_LAST, KeepLast: 4},
+	})
+	assert.NoError(t, err)
+
+	// 只识别配置的类型
+	r := parseTestMessage(t, newTestInsert(1, 10, "13812345678", "jerry@example.com"))
+	policy.Apply(r)
+	assert.Equal(t, map[string]string{"id": "10", "name": "*******5678", "email": "jerry@example.com"}, r.GetAfterColumns())
+}
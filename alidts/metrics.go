@@ -0,0 +1,272 @@
+package alidts
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 解析错误的类型
+const (
+	PARSE_ERROR_DECODE     = "decode"     // avro解码失败
+	PARSE_ERROR_VALIDATION = "validation" // 结构校验失败
+)
+
+// 消息处理的结果
+const (
+	HANDLE_RESULT_OK          = "ok"
+	HANDLE_RESULT_RETRY       = "retry"
+	HANDLE_RESULT_ERROR       = "error"
+	HANDLE_RESULT_DEAD_LETTER = "dead_letter"
+)
+
+const metricsLabelSeparator = "\xff"
+
+var (
+	parseLatencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1}
+	recordSizeBuckets   = []float64{128, 512, 1024, 4096, 16384, 65536, 262144, 1048576}
+	lagBuckets          = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 1800, 3600}
+)
+
+// WithMetrics 解析时记录指标, 使用同一个AliDts的Consumer同时记录消息的处理结果
+func WithMetrics(m *Metrics) Option {
+	return func(ad *AliDts) {
+		ad.metrics = m
+	}
+}
+
+// Metrics 解析和消费的指标, 不依赖外部的客户端库
+// 可以通过expvar或者Prometheus文本格式(ServeHTTP)导出
+type Metrics struct {
+	records      *counterVec
+	parseErrors  *counterVec
+	handled      *counterVec
+	parseLatency *histogram
+	recordSize   *histogram
+	lag          *histogram
+	now          func() time.Time
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		records:      newCounterVec("dts_records_total", "Parsed records by operation and table.", "operation", "table"),
+		parseErrors:  newCounterVec("dts_parse_errors_total", "Parse errors by kind.", "kind"),
+		handled:      newCounterVec("dts_messages_handled_total", "Consumed messages by table and handle result.", "table", "result"),
+		parseLatency: newHistogram("dts_parse_latency_seconds", "Time spent parsing a message.", parseLatencyBuckets),
+		recordSize:   newHistogram("dts_record_size_bytes", "Size of raw messages.", recordSizeBuckets),
+		lag:          newHistogram("dts_source_lag_seconds", "Delay between the source commit time and parsing.", lagBuckets),
+		now:          time.Now,
+	}
+}
+
+// observeParse 记录一次解析的结果
+func (m *Metrics) observeParse(data []byte, r *DtsRecord, err error, elapsed time.Duration) {
+	m.parseLatency.observe(elapsed.Seconds())
+	m.recordSize.observe(float64(len(data)))
+
+	if err != nil {
+		kind := PARSE_ERROR_DECODE
+		if _, ok := err.(*ValidationError); ok {
+			kind = PARSE_ERROR_VALIDATION
+		}
+		m.parseErrors.inc(kind)
+		return
+	}
+
+	m.records.inc(r.Operation, r.GetFullTableName())
+	if r.SourceTimeStamp > 0 {
+		m.lag.observe(math.Max(m.now().Sub(r.GetSourceTime()).Seconds(), 0))
+	}
+}
+
+// observeHandle 记录一条消息的处理结果, 解析失败时r为nil
+func (m *Metrics) observeHandle(r *DtsRecord, result string) {
+	table := ""
+	if r != nil {
+		table = r.GetFullTableName()
+	}
+	m.handled.inc(table, result)
+}
+
+// WritePrometheus 以Prometheus文本格式输出所有指标
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	m.records.writePrometheus(buf)
+	m.parseErrors.writePrometheus(buf)
+	m.handled.writePrometheus(buf)
+	m.parseLatency.writePrometheus(buf)
+	m.recordSize.writePrometheus(buf)
+	m.lag.writePrometheus(buf)
+	return errors.Wrap(buf.Flush(), "write metrics")
+}
+
+// ServeHTTP 实现http.Handler, 输出Prometheus文本格式的指标
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WritePrometheus(w)
+}
+
+// Snapshot 获取所有指标的当前值, 用于expvar等JSON格式的导出
+func (m *Metrics) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		m.records.name:      m.records.snapshot(),
+		m.parseErrors.name:  m.parseErrors.snapshot(),
+		m.handled.name:      m.handled.snapshot(),
+		m.parseLatency.name: m.parseLatency.snapshot(),
+		m.recordSize.name:   m.recordSize.snapshot(),
+		m.lag.name:          m.lag.snapshot(),
+	}
+}
+
+// PublishExpvar 将指标以name发布到expvar, 同一个name只能发布一次, 重复发布会panic
+func (m *Metrics) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Snapshot()
+	}))
+}
+
+// counterVec 带标签的计数器
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]uint64 // 标签值 -> 计数, 标签值使用metricsLabelSeparator连接
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]uint64),
+	}
+}
+
+func (c *counterVec) inc(values ...string) {
+	key := strings.Join(values, metricsLabelSeparator)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) snapshot() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make(map[string]uint64, len(c.values))
+	for key, v := range c.values {
+		values[strings.Replace(key, metricsLabelSeparator, ",", -1)] = v
+	}
+	return values
+}
+
+func (c *counterVec) writePrometheus(w *bufio.Writer) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]uint64, len(keys))
+	for i, key := range keys {
+		values[i] = c.values[key]
+	}
+	c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for i, key := range keys {
+		fmt.Fprintf(w, "%s{%s} %d\n", c.name, formatLabels(c.labels, strings.Split(key, metricsLabelSeparator)), values[i])
+	}
+}
+
+// histogram 累积分布的直方图, 上界为+Inf的桶即为count
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) snapshot() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := make(map[string]uint64, len(h.buckets))
+	for i, bound := range h.buckets {
+		buckets[formatFloat(bound)] = h.counts[i]
+	}
+	return map[string]interface{}{
+		"count":   h.count,
+		"sum":     h.sum,
+		"buckets": buckets,
+	}
+}
+
+func (h *histogram) writePrometheus(w *bufio.Writer) {
+	h.mu.Lock()
+	counts := append([]uint64{}, h.counts...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, count)
+}
+
+// formatLabels 输出Prometheus格式的标签, 标签值中的反斜杠, 双引号和换行需要转义
+func formatLabels(names, values []string) string {
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		pairs = append(pairs, name+`="`+value+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,73 @@
+package alidts
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.now = func() time.Time { return time.Unix(1600000011, 0) }
+	ad, err := New(WithMetrics(metrics), WithValidation())
+	assert.NoError(t, err)
+
+	messages := newTestMessages(3)
+	messages[1].Value = messages[1].Value[:10]
+	update := newTestInsert(4, 4, "tom", "tom@example.com")
+	update.operation = OPERATION_UPDATE
+	messages = append(messages, &Message{Topic: "dts", Offset: 3, Value: update.encode()})
+
+	failed := false
+	consumer := NewConsumer(ad, NewMemorySource(), func(ctx context.Context, r *DtsRecord, msg *Message) error {
+		if r.Id == 3 && !failed {
+			failed = true
+			return errors.New("temporary error")
+		}
+		return nil
+	})
+	consumer.MaxRetries = 1
+	consumer.RetryBackoff = time.Millisecond
+	for _, msg := range messages {
+		_ = consumer.handle(context.Background(), context.Background(), msg)
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+
+	text := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE dts_records_total counter",
+		`dts_records_total{operation="INSERT",table="shop.user"} 2`,
+		`dts_parse_errors_total{kind="decode"} 1`,
+		`dts_parse_errors_total{kind="validation"} 1`,
+		`dts_messages_handled_total{table="",result="error"} 2`,
+		`dts_messages_handled_total{table="shop.user",result="ok"} 2`,
+		`dts_messages_handled_total{table="shop.user",result="retry"} 1`,
+		"# TYPE dts_parse_latency_seconds histogram",
+		`dts_parse_latency_seconds_bucket{le="+Inf"} 4`,
+		`dts_source_lag_seconds_bucket{le="5"} 0`,
+		`dts_source_lag_seconds_bucket{le="10"} 2`,
+		"dts_source_lag_seconds_sum 18",
+		"dts_record_size_bytes_count 4",
+	} {
+		assert.Contains(t, text, line+"\n")
+	}
+
+	snapshot := metrics.Snapshot()
+	assert.Equal(t, map[string]uint64{"INSERT,shop.user": 2}, snapshot["dts_records_total"])
+	assert.Equal(t, uint64(4), snapshot["dts_record_size_bytes"].(map[string]interface{})["count"])
+
+	// expvar的name在进程内只能发布一次, go test -count=N会多次执行
+	name := "alidts_test_metrics_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	metrics.PublishExpvar(name)
+	assert.Contains(t, expvar.Get(name).String(), `"dts_records_total":{"INSERT,shop.user":2}`)
+}
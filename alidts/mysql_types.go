@@ -20,3 +20,28 @@ const (
 	MYSQL_TYPE_BIT
 	MYSQL_TYPE_TIMESTAMP_NEW
 )
+
+const (
+	MYSQL_TYPE_DATETIME_NEW = iota + 18
+	MYSQL_TYPE_TIME_NEW
+)
+
+const (
+	MYSQL_TYPE_JSON = iota + 245
+	MYSQL_TYPE_NEWDECIMAL
+	MYSQL_TYPE_ENUM
+	MYSQL_TYPE_SET
+	MYSQL_TYPE_TINY_BLOB
+	MYSQL_TYPE_MEDIUM_BLOB
+	MYSQL_TYPE_LONG_BLOB
+	MYSQL_TYPE_BLOB
+	MYSQL_TYPE_VAR_STRING
+	MYSQL_TYPE_STRING
+	MYSQL_TYPE_GEOMETRY
+)
+
+// IsKnownMysqlType 判断字段类型编号是否是已知的mysql类型
+func IsKnownMysqlType(dataType int) bool {
+	return (dataType >= MYSQL_TYPE_DECIMAL && dataType <= MYSQL_TYPE_TIME_NEW) ||
+		(dataType >= MYSQL_TYPE_JSON && dataType <= MYSQL_TYPE_GEOMETRY)
+}
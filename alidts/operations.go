@@ -0,0 +1,22 @@
+package alidts
+
+// DTS记录的操作类型
+const (
+	OPERATION_INSERT     = "INSERT"
+	OPERATION_UPDATE     = "UPDATE"
+	OPERATION_DELETE     = "DELETE"
+	OPERATION_DDL        = "DDL"
+	OPERATION_BEGIN      = "BEGIN"
+	OPERATION_COMMIT     = "COMMIT"
+	OPERATION_ROLLBACK   = "ROLLBACK"
+	OPERATION_ABORT      = "ABORT"
+	OPERATION_HEARTBEAT  = "HEARTBEAT"
+	OPERATION_CHECKPOINT = "CHECKPOINT"
+	OPERATION_COMMAND    = "COMMAND"
+	OPERATION_FILL       = "FILL"
+	OPERATION_FINISH     = "FINISH"
+	OPERATION_CONTROL    = "CONTROL"
+	OPERATION_RDB        = "RDB"
+	OPERATION_NOOP       = "NOOP"
+	OPERATION_INIT       = "INIT"
+)
@@ -0,0 +1,181 @@
+package alidts
+
+import "sync"
+
+// OrderViolationKind 乱序的类型
+type OrderViolationKind int
+
+const (
+	ORDER_ID_REGRESSION        OrderViolationKind = iota + 1 // 同一行记录的id比上一次小
+	ORDER_TIMESTAMP_REGRESSION                               // 同一行记录的源库时间比上一次早
+	ORDER_DUPLICATE                                          // 同一行记录的id和上一次相同
+	ORDER_GAP                                                // 数据流中的id不连续
+	ORDER_STREAM_REGRESSION                                  // 数据流中的id不大于之前出现过的最大id
+)
+
+func (k OrderViolationKind) String() string {
+	switch k {
+	case ORDER_ID_REGRESSION:
+		return "id_regression"
+	case ORDER_TIMESTAMP_REGRESSION:
+		return "timestamp_regression"
+	case ORDER_DUPLICATE:
+		return "duplicate"
+	case ORDER_GAP:
+		return "gap"
+	case ORDER_STREAM_REGRESSION:
+		return "stream_regression"
+	}
+	return "unknown"
+}
+
+// OrderViolation 一次乱序的详细信息, Table和Key在ORDER_GAP和ORDER_STREAM_REGRESSION时为空
+// 数据流级别的乱序中PrevId为之前出现过的最大id
+type OrderViolation struct {
+	Kind          OrderViolationKind
+	Table         string
+	Key           string
+	PrevId        int64
+	Id            int64
+	PrevTimestamp int64
+	Timestamp     int64
+}
+
+// OrderStats 乱序的统计
+type OrderStats struct {
+	Records              int64
+	IdRegressions        int64
+	TimestampRegressions int64
+	Duplicates           int64
+	Gaps                 int64
+	StreamRegressions    int64
+}
+
+type rowPosition struct {
+	id        int64
+	timestamp int64
+}
+
+// OrderWatchdog 检测同一行数据的记录是否按顺序到达
+// 分区重新分配或者生产者异常时, 同一行的记录可能出现回退, 重复, 或者数据流出现缺口
+type OrderWatchdog struct {
+	mu      sync.Mutex
+	keyFunc KeyFunc
+	lastId  int64 // 数据流中最大的id
+	stats   OrderStats
+
+	// 只跟踪最近maxKeys行的位置, 超出后按先后顺序淘汰
+	keys *keyRing
+	rows map[string]rowPosition
+
+	// OnViolation 检测到乱序时的回调
+	OnViolation func(OrderViolation)
+}
+
+// NewOrderWatchdog 创建乱序检测, keyFunc为nil时使用PrimaryKey, maxKeys为跟踪的最大行数
+func NewOrderWatchdog(keyFunc KeyFunc, maxKeys int) *OrderWatchdog {
+	if keyFunc == nil {
+		keyFunc = PrimaryKey
+	}
+	if maxKeys <= 0 {
+		maxKeys = 1
+	}
+
+	return &OrderWatchdog{
+		keyFunc: keyFunc,
+		keys:    newKeyRing(maxKeys),
+		rows:    make(map[string]rowPosition, maxKeys),
+	}
+}
+
+// Observe 检查一条记录, 返回检测到的乱序
+func (w *OrderWatchdog) Observe(r *DtsRecord) []OrderViolation {
+	if r == nil {
+		return nil
+	}
+
+	var key string
+	if r.Table != "" {
+		key = w.keyFunc(r)
+	}
+
+	w.mu.Lock()
+	var violations []OrderViolation
+	w.stats.Records++
+
+	// 数据流级别的检测
+	switch {
+	case w.lastId > 0 && r.Id > w.lastId+1:
+		w.stats.Gaps++
+		violations = append(violations, OrderViolation{Kind: ORDER_GAP, PrevId: w.lastId, Id: r.Id})
+	case w.lastId > 0 && r.Id <= w.lastId:
+		w.stats.StreamRegressions++
+		violations = append(violations, OrderViolation{Kind: ORDER_STREAM_REGRESSION, PrevId: w.lastId, Id: r.Id})
+	}
+	if r.Id > w.lastId {
+		w.lastId = r.Id
+	}
+
+	// 行级别的检测
+	if key != "" {
+		table := r.GetFullTableName()
+		rowKey := table + "/" + key
+		current := rowPosition{id: r.Id, timestamp: r.SourceTimeStamp}
+
+		if prev, exist := w.rows[rowKey]; exist {
+			violation := OrderViolation{
+				Table:         table,
+				Key:           key,
+				PrevId:        prev.id,
+				Id:            r.Id,
+				PrevTimestamp: prev.timestamp,
+				Timestamp:     r.SourceTimeStamp,
+			}
+
+			switch {
+			case r.Id == prev.id:
+				w.stats.Duplicates++
+				violation.Kind = ORDER_DUPLICATE
+				violations = append(violations, violation)
+			case r.Id < prev.id:
+				w.stats.IdRegressions++
+				violation.Kind = ORDER_ID_REGRESSION
+				violations = append(violations, violation)
+			case r.SourceTimeStamp < prev.timestamp:
+				w.stats.TimestampRegressions++
+				violation.Kind = ORDER_TIMESTAMP_REGRESSION
+				violations = append(violations, violation)
+			}
+
+			// 只在记录更新时前移行的位置, 这样后续正常的记录不会被误报
+			if r.Id > prev.id {
+				w.rows[rowKey] = current
+			}
+		} else {
+			w.addRow(rowKey, current)
+		}
+	}
+	w.mu.Unlock()
+
+	if w.OnViolation != nil {
+		for _, violation := range violations {
+			w.OnViolation(violation)
+		}
+	}
+
+	return violations
+}
+
+// Stats 获取乱序的统计
+func (w *OrderWatchdog) Stats() OrderStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+func (w *OrderWatchdog) addRow(rowKey string, pos rowPosition) {
+	if old := w.keys.add(rowKey); old != "" {
+		delete(w.rows, old)
+	}
+	w.rows[rowKey] = pos
+}
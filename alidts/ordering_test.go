@@ -0,0 +1,75 @@
+package alidts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderWatchdog(t *testing.T) {
+	watchdog := NewOrderWatchdog(nil, 2)
+	reported := make([]OrderViolation, 0)
+	watchdog.OnViolation = func(v OrderViolation) {
+		reported = append(reported, v)
+	}
+
+	observe := func(id, userId int64) []OrderViolation {
+		return watchdog.Observe(parseTestMessage(t, newTestInsert(id, userId, "tom", "tom@example.com")))
+	}
+
+	assert.Len(t, observe(1, 10), 0)
+	assert.Len(t, observe(2, 20), 0)
+
+	// 数据流出现缺口
+	violations := observe(4, 10)
+	if assert.Len(t, violations, 1) {
+		assert.Equal(t, OrderViolation{Kind: ORDER_GAP, PrevId: 2, Id: 4}, violations[0])
+	}
+
+	// 迟到的记录同时是数据流和行的回退
+	violations = observe(3, 10)
+	if assert.Len(t, violations, 2) {
+		assert.Equal(t, OrderViolation{Kind: ORDER_STREAM_REGRESSION, PrevId: 4, Id: 3}, violations[0])
+		assert.Equal(t, OrderViolation{
+			Kind:          ORDER_ID_REGRESSION,
+			Table:         "shop.user",
+			Key:           "10",
+			PrevId:        4,
+			Id:            3,
+			PrevTimestamp: 1600000004,
+			Timestamp:     1600000003,
+		}, violations[1])
+	}
+
+	// 重复投递
+	violations = observe(4, 10)
+	if assert.Len(t, violations, 2) {
+		assert.Equal(t, ORDER_STREAM_REGRESSION, violations[0].Kind)
+		assert.Equal(t, ORDER_DUPLICATE, violations[1].Kind)
+	}
+
+	// 源库时间回退
+	m := newTestInsert(5, 10, "tom", "tom@example.com")
+	m.sourceTimestamp = 1600000000
+	violations = watchdog.Observe(parseTestMessage(t, m))
+	if assert.Len(t, violations, 1) {
+		assert.Equal(t, ORDER_TIMESTAMP_REGRESSION, violations[0].Kind)
+		assert.Equal(t, "timestamp_regression", violations[0].Kind.String())
+	}
+
+	// 超过maxKeys后最早的行被淘汰, 不再检测
+	assert.Len(t, observe(6, 30), 0)
+	m = newTestInsert(7, 10, "tom", "tom@example.com")
+	m.sourceTimestamp = 1599999999
+	assert.Len(t, watchdog.Observe(parseTestMessage(t, m)), 0)
+
+	assert.Equal(t, OrderStats{
+		Records:              8,
+		IdRegressions:        1,
+		TimestampRegressions: 1,
+		Duplicates:           1,
+		Gaps:                 1,
+		StreamRegressions:    2,
+	}, watchdog.Stats())
+	assert.Len(t, reported, 6)
+}
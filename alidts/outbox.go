@@ -0,0 +1,165 @@
+package alidts
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// OutboxConfig outbox表的列映射, 没有设置的列使用默认的列名
+type OutboxConfig struct {
+	Table               string   // 数据库名.表名
+	IdColumn            string   // 默认: id
+	AggregateTypeColumn string   // 默认: aggregate_type
+	AggregateIdColumn   string   // 默认: aggregate_id
+	TypeColumn          string   // 默认: type
+	PayloadColumn       string   // 默认: payload, 值必须是合法的json
+	HeadersColumn       string   // 默认: headers, 值为json对象, 列不存在或者为空时忽略
+	HeaderColumns       []string // 额外作为header输出的列
+}
+
+// OutboxEvent 从outbox表的INSERT记录中提取出的领域事件
+type OutboxEvent struct {
+	Id              string            `json:"id"`
+	AggregateType   string            `json:"aggregateType"`
+	AggregateID     string            `json:"aggregateId"`
+	Type            string            `json:"type"`
+	Payload         json.RawMessage   `json:"payload"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Database        string            `json:"database"`
+	Table           string            `json:"table"`
+	SourceTimeStamp int64             `json:"sourceTimestamp"`
+	SourceTxId      string            `json:"sourceTxId"`
+}
+
+// Outbox 将写入outbox表的记录转换成领域事件, 服务可以直接从DTS数据流中发布事件
+type Outbox struct {
+	configs map[string]OutboxConfig
+}
+
+func NewOutbox(configs ...OutboxConfig) *Outbox {
+	o := &Outbox{
+		configs: make(map[string]OutboxConfig),
+	}
+
+	for _, config := range configs {
+		if config.IdColumn == "" {
+			config.IdColumn = "id"
+		}
+		if config.AggregateTypeColumn == "" {
+			config.AggregateTypeColumn = "aggregate_type"
+		}
+		if config.AggregateIdColumn == "" {
+			config.AggregateIdColumn = "aggregate_id"
+		}
+		if config.TypeColumn == "" {
+			config.TypeColumn = "type"
+		}
+		if config.PayloadColumn == "" {
+			config.PayloadColumn = "payload"
+		}
+		if config.HeadersColumn == "" {
+			config.HeadersColumn = "headers"
+		}
+		o.configs[config.Table] = config
+	}
+	return o
+}
+
+// IsOutboxTable 记录是否来自配置的outbox表
+func (o *Outbox) IsOutboxTable(r *DtsRecord) bool {
+	_, exist := o.configs[r.GetFullTableName()]
+	return exist
+}
+
+// Extract 从记录中提取领域事件
+// 只有outbox表的INSERT记录才会产生事件, 其他记录返回nil, nil
+// outbox表中的行一般在写入后会被删除, 对应的DELETE记录会被忽略
+// 映射的id, aggregate, type和payload列不存在或者为NULL时返回错误
+func (o *Outbox) Extract(r *DtsRecord) (*OutboxEvent, error) {
+	if r == nil || r.Operation != OPERATION_INSERT {
+		return nil, nil
+	}
+
+	config, exist := o.configs[r.GetFullTableName()]
+	if !exist {
+		return nil, nil
+	}
+
+	cols := r.GetAfterColumns()
+	if cols == nil {
+		return nil, errors.Errorf("missing after image, table: %s, id: %d", config.Table, r.Id)
+	}
+
+	// 映射的列不存在或者为NULL时返回错误, 避免输出空的id或者type
+	nullable := r.getNullableColumns(r.AfterImages)
+	for _, column := range []string{config.IdColumn, config.AggregateTypeColumn, config.AggregateIdColumn, config.TypeColumn, config.PayloadColumn} {
+		if nullable[column] == nil {
+			return nil, errors.Errorf("outbox column %s is missing or null, table: %s, id: %d", column, config.Table, r.Id)
+		}
+	}
+
+	payload := cols[config.PayloadColumn]
+	if !json.Valid([]byte(payload)) {
+		return nil, errors.Errorf("invalid outbox payload, table: %s, id: %d", config.Table, r.Id)
+	}
+
+	event := &OutboxEvent{
+		Id:              cols[config.IdColumn],
+		AggregateType:   cols[config.AggregateTypeColumn],
+		AggregateID:     cols[config.AggregateIdColumn],
+		Type:            cols[config.TypeColumn],
+		Payload:         json.RawMessage(payload),
+		Database:        r.Database,
+		Table:           r.Table,
+		SourceTimeStamp: r.SourceTimeStamp,
+		SourceTxId:      r.SourceTxId,
+	}
+
+	if headers := cols[config.HeadersColumn]; headers != "" {
+		var values map[string]json.RawMessage
+		err := json.Unmarshal([]byte(headers), &values)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid outbox headers, table: %s, id: %d", config.Table, r.Id)
+		}
+
+		event.Headers = make(map[string]string, len(values))
+		for k, v := range values {
+			event.Headers[k], err = outboxHeaderValue(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid outbox header %s, table: %s, id: %d", k, config.Table, r.Id)
+			}
+		}
+	}
+
+	for _, column := range config.HeaderColumns {
+		if v, exist := cols[column]; exist {
+			if event.Headers == nil {
+				event.Headers = make(map[string]string)
+			}
+			event.Headers[column] = v
+		}
+	}
+
+	return event, nil
+}
+
+// outboxHeaderValue 将headers中的json值转换成字符串
+// 字符串原样输出, 数字保留原始的写法, 对象和数组输出为紧凑的json, null输出为空字符串
+func outboxHeaderValue(v json.RawMessage) (string, error) {
+	v = bytes.TrimSpace(v)
+	switch {
+	case len(v) == 0, string(v) == "null":
+		return "", nil
+	case v[0] == '"':
+		var s string
+		err := json.Unmarshal(v, &s)
+		return s, err
+	case v[0] == '{', v[0] == '[':
+		var b bytes.Buffer
+		err := json.Compact(&b, v)
+		return b.String(), err
+	}
+	return string(v), nil
+}
@@ -0,0 +1,107 @@
+package alidts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testOutboxFields = []testField{
+	{name: "id", dataType: MYSQL_TYPE_INT64},
+	{name: "aggregate_type", dataType: MYSQL_TYPE_VAR_STRING},
+	{name: "aggregate_id", dataType: MYSQL_TYPE_VAR_STRING},
+	{name: "type", dataType: MYSQL_TYPE_VAR_STRING},
+	{name: "payload", dataType: MYSQL_TYPE_JSON},
+	{name: "headers", dataType: MYSQL_TYPE_JSON},
+	{name: "tenant", dataType: MYSQL_TYPE_VAR_STRING},
+}
+
+func newTestOutboxInsert(id int64, payload, headers interface{}) testMessage {
+	return testMessage{
+		id:              id,
+		sourceTimestamp: 1600000000 + id,
+		txId:            "tx1",
+		operation:       OPERATION_INSERT,
+		objectName:      "shop.outbox",
+		fields:          testOutboxFields,
+		after:           []interface{}{id, "order", "1001", "OrderCreated", payload, headers, "acme"},
+	}
+}
+
+func TestOutboxExtract(t *testing.T) {
+	outbox := NewOutbox(OutboxConfig{Table: "shop.outbox", HeaderColumns: []string{"tenant"}})
+
+	r := parseTestMessage(t, newTestOutboxInsert(1, `{"amount":10}`, `{"traceId":"abc","retry":1}`))
+	assert.True(t, outbox.IsOutboxTable(r))
+	event, err := outbox.Extract(r)
+	assert.NoError(t, err)
+	assert.Equal(t, &OutboxEvent{
+		Id:              "1",
+		AggregateType:   "order",
+		AggregateID:     "1001",
+		Type:            "OrderCreated",
+		Payload:         []byte(`{"amount":10}`),
+		Headers:         map[string]string{"traceId": "abc", "retry": "1", "tenant": "acme"},
+		Database:        "shop",
+		Table:           "outbox",
+		SourceTimeStamp: 1600000001,
+		SourceTxId:      "tx1",
+	}, event)
+
+	// 数字保留原始的写法, 对象和数组输出为紧凑的json
+	r = parseTestMessage(t, newTestOutboxInsert(2, `{}`, `{"count":1000000,"userId":9007199254740993,"ratio":0.10,"meta":{ "a" : [1, "x"] },"tags":["a", "b"],"empty":null,"ok":true}`))
+	event, err = outbox.Extract(r)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"count":  "1000000",
+		"userId": "9007199254740993",
+		"ratio":  "0.10",
+		"meta":   `{"a":[1,"x"]}`,
+		"tags":   `["a","b"]`,
+		"empty":  "",
+		"ok":     "true",
+		"tenant": "acme",
+	}, event.Headers)
+
+	// headers为NULL时忽略
+	event, err = outbox.Extract(parseTestMessage(t, newTestOutboxInsert(2, `{}`, nil)))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"tenant": "acme"}, event.Headers)
+
+	// 不是outbox表的INSERT记录不产生事件
+	remove := newTestOutboxInsert(3, `{}`, nil)
+	remove.operation = OPERATION_DELETE
+	remove.before, remove.after = remove.after, nil
+	event, err = outbox.Extract(parseTestMessage(t, remove))
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+
+	event, err = outbox.Extract(parseTestMessage(t, newTestInsert(4, 10, "tom", "tom@example.com")))
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+}
+
+func TestOutboxExtractInvalid(t *testing.T) {
+	outbox := NewOutbox(OutboxConfig{Table: "shop.outbox"})
+
+	_, err := outbox.Extract(parseTestMessage(t, newTestOutboxInsert(1, `{"amount":`, nil)))
+	assert.Error(t, err)
+
+	_, err = outbox.Extract(parseTestMessage(t, newTestOutboxInsert(2, `{}`, `[1]`)))
+	assert.Error(t, err)
+
+	// 映射的列为NULL
+	m := newTestOutboxInsert(3, `{}`, nil)
+	m.after[3] = nil
+	_, err = outbox.Extract(parseTestMessage(t, m))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "outbox column type is missing or null")
+	}
+
+	// 配置的列不存在
+	outbox = NewOutbox(OutboxConfig{Table: "shop.outbox", AggregateIdColumn: "order_id"})
+	_, err = outbox.Extract(parseTestMessage(t, newTestOutboxInsert(4, `{}`, nil)))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "outbox column order_id is missing or null")
+	}
+}
@@ -0,0 +1,170 @@
+package alidts
+
+import (
+	"strings"
+	"sync"
+)
+
+// Phase 记录所处的阶段
+type Phase int
+
+const (
+	PHASE_UNKNOWN     Phase = iota
+	PHASE_SNAPSHOT          // 全量初始化阶段的数据行
+	PHASE_INCREMENTAL       // 增量阶段的数据变更
+	PHASE_CONTROL           // 事务边界, 心跳, 全量开始/结束等控制信息
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PHASE_SNAPSHOT:
+		return "snapshot"
+	case PHASE_INCREMENTAL:
+		return "incremental"
+	case PHASE_CONTROL:
+		return "control"
+	}
+	return "unknown"
+}
+
+// getPhase 根据操作类型判断记录所处的阶段
+func getPhase(operation string) Phase {
+	switch operation {
+	case OPERATION_FILL:
+		return PHASE_SNAPSHOT
+	case OPERATION_INSERT, OPERATION_UPDATE, OPERATION_DELETE, OPERATION_DDL:
+		return PHASE_INCREMENTAL
+	case OPERATION_INIT, OPERATION_FINISH,
+		OPERATION_BEGIN, OPERATION_COMMIT, OPERATION_ROLLBACK, OPERATION_ABORT,
+		OPERATION_HEARTBEAT, OPERATION_CHECKPOINT, OPERATION_COMMAND,
+		OPERATION_CONTROL, OPERATION_RDB, OPERATION_NOOP:
+		return PHASE_CONTROL
+	}
+	return PHASE_UNKNOWN
+}
+
+// SnapshotState 表的全量初始化状态
+type SnapshotState int
+
+const (
+	SNAPSHOT_STATE_NONE     SnapshotState = iota // 还没有收到该表的全量数据
+	SNAPSHOT_STATE_LOADING                       // 正在接收全量数据
+	SNAPSHOT_STATE_FINISHED                      // 全量数据已经接收完成
+)
+
+func (s SnapshotState) String() string {
+	switch s {
+	case SNAPSHOT_STATE_LOADING:
+		return "loading"
+	case SNAPSHOT_STATE_FINISHED:
+		return "finished"
+	}
+	return "none"
+}
+
+// SnapshotTracker 跟踪每张表的全量初始化状态
+//
+//	NONE --INIT/FILL--> LOADING --FINISH--> FINISHED
+//
+// 只带库名的FINISH表示该库的全量阶段结束, 该库正在加载的表都会被置为FINISHED,
+// 库名和表名都没有的FINISH表示整个全量阶段结束, 所有正在加载的表都会被置为FINISHED
+type SnapshotTracker struct {
+	mu     sync.RWMutex
+	states map[string]SnapshotState
+
+	// OnSnapshotStarted 某张表开始接收全量数据时回调
+	OnSnapshotStarted func(database, table string)
+	// OnSnapshotFinished 某张表全量数据接收完成时回调, 此时可以认为目标端已经完成初始化
+	OnSnapshotFinished func(database, table string)
+}
+
+func NewSnapshotTracker() *SnapshotTracker {
+	return &SnapshotTracker{
+		states: make(map[string]SnapshotState),
+	}
+}
+
+// Apply 根据记录推进状态机, 返回记录所属表的最新状态
+func (t *SnapshotTracker) Apply(r *DtsRecord) SnapshotState {
+	if r == nil {
+		return SNAPSHOT_STATE_NONE
+	}
+
+	var started, finished []string
+
+	t.mu.Lock()
+	name := r.GetFullTableName()
+	switch r.Operation {
+	case OPERATION_INIT, OPERATION_FILL:
+		if r.Table != "" && t.states[name] == SNAPSHOT_STATE_NONE {
+			t.states[name] = SNAPSHOT_STATE_LOADING
+			started = append(started, name)
+		}
+	case OPERATION_FINISH:
+		if r.Table != "" {
+			if t.states[name] != SNAPSHOT_STATE_FINISHED {
+				t.states[name] = SNAPSHOT_STATE_FINISHED
+				finished = append(finished, name)
+			}
+		} else {
+			// 带库名的FINISH只结束该库的表
+			prefix := r.Database + "."
+			for tableName, state := range t.states {
+				if state == SNAPSHOT_STATE_LOADING && (r.Database == "" || strings.HasPrefix(tableName, prefix)) {
+					t.states[tableName] = SNAPSHOT_STATE_FINISHED
+					finished = append(finished, tableName)
+				}
+			}
+		}
+	}
+	state := t.states[name]
+	t.mu.Unlock()
+
+	// 在锁外回调, 避免回调中再次访问tracker导致死锁
+	for _, name := range started {
+		if t.OnSnapshotStarted != nil {
+			t.OnSnapshotStarted(splitTableName(name))
+		}
+	}
+	for _, name := range finished {
+		if t.OnSnapshotFinished != nil {
+			t.OnSnapshotFinished(splitTableName(name))
+		}
+	}
+
+	return state
+}
+
+// State 获取某张表的全量初始化状态
+func (t *SnapshotTracker) State(database, table string) SnapshotState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.states[database+"."+table]
+}
+
+// IsSnapshotFinished 某张表的全量数据是否已经接收完成
+func (t *SnapshotTracker) IsSnapshotFinished(database, table string) bool {
+	return t.State(database, table) == SNAPSHOT_STATE_FINISHED
+}
+
+// IsLoading 是否还有表正在接收全量数据
+func (t *SnapshotTracker) IsLoading() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, state := range t.states {
+		if state == SNAPSHOT_STATE_LOADING {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTableName 将"数据库名.表名"拆分成数据库名和表名
+func splitTableName(name string) (string, string) {
+	tokens := strings.SplitN(name, ".", 2)
+	if len(tokens) == 2 {
+		return tokens[0], tokens[1]
+	}
+	return tokens[0], ""
+}
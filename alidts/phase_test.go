@@ -0,0 +1,86 @@
+package alidts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPhase(t *testing.T) {
+	assert.Equal(t, PHASE_SNAPSHOT, getPhase(OPERATION_FILL))
+	for _, op := range []string{OPERATION_INSERT, OPERATION_UPDATE, OPERATION_DELETE, OPERATION_DDL} {
+		assert.Equal(t, PHASE_INCREMENTAL, getPhase(op), op)
+	}
+	for _, op := range []string{OPERATION_INIT, OPERATION_FINISH, OPERATION_BEGIN, OPERATION_COMMIT, OPERATION_HEARTBEAT} {
+		assert.Equal(t, PHASE_CONTROL, getPhase(op), op)
+	}
+	assert.Equal(t, PHASE_UNKNOWN, getPhase("UNKNOWN"))
+	assert.Equal(t, "snapshot", PHASE_SNAPSHOT.String())
+	assert.Equal(t, "unknown", PHASE_UNKNOWN.String())
+
+	fill := newTestInsert(1, 10, "tom", "tom@example.com")
+	fill.operation = OPERATION_FILL
+	assert.Equal(t, PHASE_SNAPSHOT, parseTestMessage(t, fill).Phase)
+}
+
+func TestSnapshotTracker(t *testing.T) {
+	tracker := NewSnapshotTracker()
+	started := make([]string, 0)
+	finished := make([]string, 0)
+	tracker.OnSnapshotStarted = func(database, table string) {
+		started = append(started, database+"."+table)
+	}
+	tracker.OnSnapshotFinished = func(database, table string) {
+		finished = append(finished, database+"."+table)
+	}
+
+	apply := func(m testMessage) SnapshotState {
+		return tracker.Apply(parseTestMessage(t, m))
+	}
+
+	assert.Equal(t, SNAPSHOT_STATE_LOADING, apply(testMessage{id: 1, operation: OPERATION_INIT, objectName: "shop.user"}))
+	fill := newTestInsert(2, 10, "tom", "tom@example.com")
+	fill.operation = OPERATION_FILL
+	assert.Equal(t, SNAPSHOT_STATE_LOADING, apply(fill))
+	assert.Equal(t, []string{"shop.user"}, started)
+	assert.True(t, tracker.IsLoading())
+
+	// 增量的记录不改变状态
+	assert.Equal(t, SNAPSHOT_STATE_LOADING, apply(newTestInsert(3, 10, "tom", "tom@example.com")))
+	assert.Equal(t, SNAPSHOT_STATE_LOADING, tracker.State("shop", "user"))
+
+	assert.Equal(t, SNAPSHOT_STATE_FINISHED, apply(testMessage{id: 4, operation: OPERATION_FINISH, objectName: "shop.user"}))
+	assert.True(t, tracker.IsSnapshotFinished("shop", "user"))
+	assert.False(t, tracker.IsLoading())
+	assert.Equal(t, []string{"shop.user"}, finished)
+
+	// 已经完成的表不会再次开始
+	assert.Equal(t, SNAPSHOT_STATE_FINISHED, apply(fill))
+	assert.Len(t, started, 1)
+	assert.Equal(t, "finished", tracker.State("shop", "user").String())
+	assert.Equal(t, "none", tracker.State("shop", "order").String())
+}
+
+func TestSnapshotTrackerFinishDatabase(t *testing.T) {
+	tracker := NewSnapshotTracker()
+	finished := make([]string, 0)
+	tracker.OnSnapshotFinished = func(database, table string) {
+		finished = append(finished, database+"."+table)
+	}
+
+	for i, name := range []string{"shop.user", "shop.order", "crm.customer"} {
+		tracker.Apply(parseTestMessage(t, testMessage{id: int64(i), operation: OPERATION_INIT, objectName: name}))
+	}
+
+	// 只带库名的FINISH只结束该库的表
+	tracker.Apply(parseTestMessage(t, testMessage{id: 4, operation: OPERATION_FINISH, objectName: "shop"}))
+	assert.ElementsMatch(t, []string{"shop.user", "shop.order"}, finished)
+	assert.Equal(t, SNAPSHOT_STATE_LOADING, tracker.State("crm", "customer"))
+	assert.True(t, tracker.IsLoading())
+
+	// 不带库名和表名的FINISH结束所有表
+	tracker.Apply(parseTestMessage(t, testMessage{id: 5, operation: OPERATION_FINISH}))
+	assert.Equal(t, SNAPSHOT_STATE_FINISHED, tracker.State("crm", "customer"))
+	assert.False(t, tracker.IsLoading())
+	assert.Len(t, finished, 3)
+}
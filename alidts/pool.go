@@ -0,0 +1,125 @@
+package alidts
+
+import "sync"
+
+var recordPool = sync.Pool{
+	New: func() interface{} {
+		return &DtsRecord{}
+	},
+}
+
+// AcquireRecord 从对象池中获取一个记录, 一般和ParseInto配合使用
+//
+//	r := alidts.AcquireRecord()
+//	defer r.Release()
+//	err := ad.ParseInto(data, r)
+//
+// 记录的所有权属于调用方, 直到调用Release为止;
+// Release之后不能再访问记录, ObjectName, Tags和TableFields会被下一次解析复用, 需要继续使用时先复制一份,
+// Fields, ProcessTimestamps, BeforeImages和AfterImages每次解析都会重新分配, 不会被复用,
+// GetAfterColumns等方法返回的map也不受Release影响
+func AcquireRecord() *DtsRecord {
+	return recordPool.Get().(*DtsRecord)
+}
+
+// Release 将记录归还到对象池中, 调用后不能再使用该记录
+func (r *DtsRecord) Release() {
+	if r == nil {
+		return
+	}
+
+	r.reset()
+	recordPool.Put(r)
+}
+
+// reset 清空记录的内容, 保留可以复用的ObjectName, Tags和TableFields
+func (r *DtsRecord) reset() {
+	objectName := r.ObjectName
+	for k := range objectName {
+		delete(objectName, k)
+	}
+
+	tags := r.Tags
+	for k := range tags {
+		delete(tags, k)
+	}
+
+	tableFields := r.TableFields[:0]
+
+	*r = DtsRecord{
+		ObjectName:  objectName,
+		Tags:        tags,
+		TableFields: tableFields,
+	}
+}
+
+// setField 设置从avro中解码出的顶层字段
+func (r *DtsRecord) setField(name string, v interface{}) {
+	switch name {
+	case "version":
+		r.Version, _ = v.(int)
+	case "id":
+		r.Id, _ = v.(int64)
+	case "sourceTimestamp":
+		r.SourceTimeStamp, _ = v.(int64)
+	case "sourceTxid":
+		r.SourceTxId, _ = v.(string)
+	case "operation":
+		r.Operation, _ = v.(string)
+	case "objectName":
+		if union, ok := v.(map[string]interface{}); ok {
+			if r.ObjectName == nil {
+				r.ObjectName = make(map[string]string, 1)
+			}
+			r.ObjectName["string"], _ = union["string"].(string)
+		}
+	case "processTimestamps":
+		// avro解码的union每次都是新的map, 直接使用, 不复用
+		r.ProcessTimestamps, _ = v.(map[string]interface{})
+	case "tags":
+		if tags, ok := v.(map[string]interface{}); ok && len(tags) > 0 {
+			if r.Tags == nil {
+				r.Tags = make(map[string]string, len(tags))
+			}
+			for k, tag := range tags {
+				r.Tags[k], _ = tag.(string)
+			}
+		}
+	case "fields":
+		// Fields和镜像同样是解码器新分配的, 只有由它们解析出的TableFields被复用
+		r.Fields, _ = v.(map[string]interface{})
+		r.setTableFieldsFromRaw()
+	case "beforeImages":
+		r.BeforeImages, _ = v.(map[string]interface{})
+	case "afterImages":
+		r.AfterImages, _ = v.(map[string]interface{})
+	}
+}
+
+// setTableFieldsFromRaw 从Fields中解析出TableFields, 复用TableFields中已有的DtsField
+func (r *DtsRecord) setTableFieldsFromRaw() {
+	items, ok := r.Fields["array"].([]interface{})
+	if !ok {
+		return
+	}
+
+	fields := r.TableFields[:0]
+	reusable := r.TableFields[:cap(r.TableFields)]
+	for i, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var field *DtsField
+		if i < len(reusable) && reusable[i] != nil {
+			field = reusable[i]
+		} else {
+			field = &DtsField{}
+		}
+		field.Name, _ = m["name"].(string)
+		field.DataType, _ = m["dataTypeNumber"].(int)
+		fields = append(fields, field)
+	}
+	r.TableFields = fields
+}
@@ -0,0 +1,113 @@
+package alidts
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hamba/avro"
+	"github.com/mitchellh/mapstructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIntoReuse(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	r := AcquireRecord()
+	m := newTestInsert(1, 10, "tom", "tom@example.com")
+	assert.NoError(t, ad.ParseInto(m.encode(), r))
+	assert.Equal(t, "shop.user", r.GetFullTableName())
+	assert.Len(t, r.TableFields, 3)
+	r.Release()
+
+	// 复用的记录中不能残留上一条记录的内容
+	r = AcquireRecord()
+	defer r.Release()
+	heartbeat := testMessage{id: 2, operation: OPERATION_HEARTBEAT}
+	assert.NoError(t, ad.ParseInto(heartbeat.encode(), r))
+	assert.Equal(t, int64(2), r.Id)
+	assert.Equal(t, "", r.GetFullTableName())
+	assert.Empty(t, r.Tags)
+	assert.Empty(t, r.TableFields)
+	assert.Nil(t, r.GetAfterColumns())
+}
+
+func TestParseIntoOwnership(t *testing.T) {
+	ad, err := New()
+	assert.NoError(t, err)
+
+	var r DtsRecord
+	assert.NoError(t, ad.ParseInto(newTestInsert(1, 10, "tom", "tom@example.com").encode(), &r))
+	fields, after, tags := r.Fields, r.AfterImages, r.Tags
+	printed := fmt.Sprint(fields, after)
+
+	// 镜像和Fields不会被下一次解析修改, Tags会被复用
+	assert.NoError(t, ad.ParseInto(newTestInsert(2, 20, "jerry", "jerry@example.com").encode(), &r))
+	assert.Equal(t, map[string]string{"id": "20", "name": "jerry", "email": "jerry@example.com"}, r.GetAfterColumns())
+	assert.Equal(t, printed, fmt.Sprint(fields, after))
+	assert.Contains(t, printed, "value:10]")
+	assert.Equal(t, fmt.Sprintf("%p", tags), fmt.Sprintf("%p", r.Tags))
+}
+
+// parseWithMapstructure 之前基于mapstructure的解析方式, 作为性能对比的基准
+func parseWithMapstructure(ad *AliDts, data []byte) (*DtsRecord, error) {
+	var v interface{}
+	err := avro.Unmarshal(ad.schema.schema, data, &v)
+	if err != nil {
+		return nil, err
+	}
+
+	var r DtsRecord
+	err = mapstructure.Decode(v, &r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, r.parse()
+}
+
+func benchmarkMessage(b *testing.B) (*AliDts, []byte) {
+	ad, err := New()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	m := newTestInsert(1, 10, "tom", "tom@example.com")
+	m.operation = OPERATION_UPDATE
+	m.before = []interface{}{int64(10), "tommy", "tom@example.com"}
+	return ad, m.encode()
+}
+
+func BenchmarkParseMapstructure(b *testing.B) {
+	ad, data := benchmarkMessage(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseWithMapstructure(ad, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	ad, data := benchmarkMessage(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ad.Parse(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseIntoPooled(b *testing.B) {
+	ad, data := benchmarkMessage(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := AcquireRecord()
+		if err := ad.ParseInto(data, r); err != nil {
+			b.Fatal(err)
+		}
+		r.Release()
+	}
+}
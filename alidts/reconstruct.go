@@ -0,0 +1,151 @@
+package alidts
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RowChange 行的一次变化, INSERT时Before为nil, DELETE时After为nil
+type RowChange struct {
+	Id              int64
+	SourceTimeStamp int64
+	SourceTxId      string
+	Operation       string
+	Key             string
+	Before          map[string]string
+	After           map[string]string
+	Changed         []string // 发生变化的列
+}
+
+// RowHistory 行在某个时间点的状态和到该时间点为止的变化历史
+type RowHistory struct {
+	Database string
+	Table    string
+	Key      string            // 行当前的唯一键, UPDATE修改唯一键后会跟随变化
+	Exists   bool              // 行在该时间点是否存在
+	Row      map[string]string // 行在该时间点的值, 不存在时为nil
+	Changes  []*RowChange
+}
+
+// RowReconstructor 回放一个表的变更, 重建某一行在某个时间点的状态, 用于排查问题
+// 记录需要按照产生的顺序回放, 超过UntilTime或UntilId的记录会被忽略
+type RowReconstructor struct {
+	keyFunc KeyFunc
+	table   string
+	history RowHistory
+
+	// UntilTime 只回放源库时间不晚于该时间的记录, 零值表示不限制
+	UntilTime time.Time
+	// UntilId 只回放Id不大于该值的记录, <=0表示不限制
+	UntilId int64
+}
+
+// NewRowReconstructor 创建重建器, table为"数据库名.表名", key为行的唯一键, keyFunc为nil时使用PrimaryKey
+func NewRowReconstructor(table string, key string, keyFunc KeyFunc) *RowReconstructor {
+	if keyFunc == nil {
+		keyFunc = PrimaryKey
+	}
+
+	db, tbl := splitObjectName(table)
+	return &RowReconstructor{
+		keyFunc: keyFunc,
+		table:   table,
+		history: RowHistory{
+			Database: db,
+			Table:    tbl,
+			Key:      key,
+			Changes:  make([]*RowChange, 0),
+		},
+	}
+}
+
+// Apply 回放一条记录, 不属于该行的记录会被忽略
+// INIT表示重新开始全量初始化, 之前的状态会被清空, 历史保留
+func (rr *RowReconstructor) Apply(r *DtsRecord) error {
+	if r == nil || r.GetFullTableName() != rr.table || !rr.inRange(r) {
+		return nil
+	}
+
+	switch r.Operation {
+	case OPERATION_INIT:
+		rr.history.Exists = false
+		rr.history.Row = nil
+		return nil
+	case OPERATION_INSERT, OPERATION_FILL, OPERATION_UPDATE, OPERATION_DELETE:
+	default:
+		return nil
+	}
+
+	key := rr.keyFunc(r)
+	if key == "" {
+		return errors.Errorf("can not get row key, table: %s, id: %d", rr.table, r.Id)
+	}
+
+	// UPDATE修改了唯一键时, 旧唯一键对应的行变成新唯一键
+	oldKey := key
+	if r.Operation == OPERATION_UPDATE {
+		oldKey = r.GetBeforeKey(rr.keyFunc)
+	}
+
+	switch rr.history.Key {
+	case oldKey:
+	case key:
+		// 其他行的唯一键修改成了当前行, 当前行的历史从这里开始
+		rr.history.Exists = false
+		rr.history.Row = nil
+	default:
+		return nil
+	}
+
+	change := &RowChange{
+		Id:              r.Id,
+		SourceTimeStamp: r.SourceTimeStamp,
+		SourceTxId:      r.SourceTxId,
+		Operation:       r.Operation,
+		Key:             key,
+		Before:          rr.history.Row,
+		Changed:         r.GetChangedColumns(),
+	}
+	if r.Operation != OPERATION_DELETE {
+		change.After = r.GetAfterColumns()
+		if change.After == nil {
+			return errors.Errorf("missing after image, table: %s, id: %d", rr.table, r.Id)
+		}
+	}
+
+	// 从中途开始回放时, 使用记录中的改变前镜像
+	if change.Before == nil {
+		change.Before = r.GetBeforeColumns()
+	}
+
+	rr.history.Key = key
+	rr.history.Row = change.After
+	rr.history.Exists = change.After != nil
+	rr.history.Changes = append(rr.history.Changes, change)
+	return nil
+}
+
+// ReplayArchive 读取ArchiveWriter写入的归档目录, 回放该表的所有记录
+func (rr *RowReconstructor) ReplayArchive(dir string) error {
+	db, tbl := rr.history.Database, rr.history.Table
+	return ReadArchive(filepath.Join(dir, archiveObjectName(db), archiveObjectName(tbl)), rr.Apply)
+}
+
+// History 获取行在回放到的时间点的状态和变化历史
+func (rr *RowReconstructor) History() *RowHistory {
+	history := rr.history
+	return &history
+}
+
+// inRange 记录是否在回放的范围内
+func (rr *RowReconstructor) inRange(r *DtsRecord) bool {
+	if rr.UntilId > 0 && r.Id > rr.UntilId {
+		return false
+	}
+	if !rr.UntilTime.IsZero() && r.GetSourceTime().After(rr.UntilTime) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package alidts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChanges() []testMessage {
+	insert := newTestInsert(1, 10, "tom", "tom@example.com")
+
+	rename := newTestInsert(2, 10, "tommy", "tom@example.com")
+	rename.operation = OPERATION_UPDATE
+	rename.before = []interface{}{int64(10), "tom", "tom@example.com"}
+
+	other := newTestInsert(3, 20, "jerry", "jerry@example.com")
+
+	rekey := newTestInsert(4, 11, "tommy", "tom@example.com")
+	rekey.operation = OPERATION_UPDATE
+	rekey.before = []interface{}{int64(10), "tommy", "tom@example.com"}
+
+	remove := newTestInsert(5, 0, "", "")
+	remove.operation = OPERATION_DELETE
+	remove.before = []interface{}{int64(11), "tommy", "tom@example.com"}
+	remove.after = nil
+
+	return []testMessage{insert, rename, other, rekey, remove}
+}
+
+func TestRowReconstructor(t *testing.T) {
+	records := make([]*DtsRecord, 0)
+	for _, m := range newTestChanges() {
+		records = append(records, parseTestMessage(t, m))
+	}
+	assert.Equal(t, []string{"name"}, records[1].GetChangedColumns())
+	assert.Equal(t, []string{"id"}, records[3].GetChangedColumns())
+
+	rr := NewRowReconstructor("shop.user", "10", nil)
+	rr.UntilTime = time.Unix(1600000002, 0)
+	for _, r := range records {
+		assert.NoError(t, rr.Apply(r))
+	}
+	history := rr.History()
+	assert.True(t, history.Exists)
+	assert.Equal(t, map[string]string{"id": "10", "name": "tommy", "email": "tom@example.com"}, history.Row)
+	if assert.Len(t, history.Changes, 2) {
+		assert.Nil(t, history.Changes[0].Before)
+		assert.Equal(t, "tom", history.Changes[1].Before["name"])
+		assert.Equal(t, []string{"name"}, history.Changes[1].Changed)
+	}
+
+	// 唯一键修改后继续跟随该行
+	rr = NewRowReconstructor("shop.user", "10", nil)
+	rr.UntilId = 4
+	for _, r := range records {
+		assert.NoError(t, rr.Apply(r))
+	}
+	history = rr.History()
+	assert.Equal(t, "11", history.Key)
+	assert.Equal(t, "11", history.Row["id"])
+	assert.Len(t, history.Changes, 3)
+}
+
+func TestRowReconstructorReplayArchive(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewArchiveWriter(dir, ARCHIVE_FORMAT_NDJSON)
+	assert.NoError(t, err)
+	for _, m := range newTestChanges() {
+		assert.NoError(t, w.Write(parseTestMessage(t, m)))
+	}
+	assert.NoError(t, w.Close())
+
+	rr := NewRowReconstructor("shop.user", "10", nil)
+	assert.NoError(t, rr.ReplayArchive(dir))
+	history := rr.History()
+	assert.False(t, history.Exists)
+	assert.Nil(t, history.Row)
+	if assert.Len(t, history.Changes, 4) {
+		last := history.Changes[3]
+		assert.Equal(t, OPERATION_DELETE, last.Operation)
+		assert.Equal(t, "tommy", last.Before["name"])
+	}
+}
@@ -5,29 +5,33 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // DtsRecord 原始的记录
 type DtsRecord struct {
-	Version         int                    `mapstructure:"version"`
-	Id              int64                  `mapstructure:"id"`
-	SourceTimeStamp int64                  `mapstructure:"sourceTimestamp"`
-	SourceTxId      string                 `mapstructure:"sourceTxid"`
-	ObjectName      map[string]string      `mapstructure:"objectName"` // 数据库名.表名
-	Operation       string                 `mapstructure:"operation"`
-	Fields          map[string]interface{} `mapstructure:"fields"`       // 字段slice
-	BeforeImages    map[string]interface{} `mapstructure:"beforeImages"` // 改变前
-	AfterImages     map[string]interface{} `mapstructure:"afterImages"`  // 改变后
+	Version           int                    `mapstructure:"version"`
+	Id                int64                  `mapstructure:"id"`
+	SourceTimeStamp   int64                  `mapstructure:"sourceTimestamp"`
+	SourceTxId        string                 `mapstructure:"sourceTxid"`
+	ObjectName        map[string]string      `mapstructure:"objectName"` // 数据库名.表名
+	Operation         string                 `mapstructure:"operation"`
+	Fields            map[string]interface{} `mapstructure:"fields"`            // 字段slice
+	BeforeImages      map[string]interface{} `mapstructure:"beforeImages"`      // 改变前
+	AfterImages       map[string]interface{} `mapstructure:"afterImages"`       // 改变后
+	ProcessTimestamps map[string]interface{} `mapstructure:"processTimestamps"` // DTS各处理环节的时间戳(毫秒)
+	Tags              map[string]string      `mapstructure:"tags"`
 
 	// 额外的字段
 	Database    string
 	Table       string
 	TableFields []*DtsField
+	Phase       Phase // 记录所处的阶段: 全量/增量/控制
 }
 
 type DtsField struct {
-	Name     string `mapstructure:"name"`
-	DataType int    `mapstructure:"dataTypeNumber"`
+	Name     string `mapstructure:"name" json:"name"`
+	DataType int    `mapstructure:"dataTypeNumber" json:"dataTypeNumber"`
 }
 
 type DtsFields struct {
@@ -55,6 +59,10 @@ type DtsTypeValue struct {
 	Value string `mapstructure:"value"`
 }
 
+type DtsTypeFloat struct {
+	Value float64 `mapstructure:"value"`
+}
+
 type DtsTypeBytes struct {
 	Value []byte `mapstructure:"value"`
 }
@@ -67,24 +75,86 @@ func (r *DtsRecord) GetBeforeColumns() map[string]string {
 	return r.getColumns(r.BeforeImages)
 }
 
+// GetChangedColumns 获取发生变化的列名, 按照字段顺序排列
+// UPDATE返回改变前后值不同的列(包括NULL的变化), INSERT和DELETE返回所有列
+func (r *DtsRecord) GetChangedColumns() []string {
+	before := r.getNullableColumns(r.BeforeImages)
+	after := r.getNullableColumns(r.AfterImages)
+	if before == nil && after == nil {
+		return nil
+	}
+
+	changed := make([]string, 0)
+	for _, field := range r.TableFields {
+		if field == nil {
+			continue
+		}
+
+		if before == nil || after == nil {
+			changed = append(changed, field.Name)
+			continue
+		}
+		b, a := before[field.Name], after[field.Name]
+		if (b == nil) != (a == nil) || (b != nil && *b != *a) {
+			changed = append(changed, field.Name)
+		}
+	}
+	return changed
+}
+
+// GetFullTableName 获取"数据库名.表名"形式的完整表名
+func (r *DtsRecord) GetFullTableName() string {
+	if r.Table == "" {
+		return r.Database
+	}
+	return r.Database + "." + r.Table
+}
+
+// GetSourceTime 获取记录在源库产生的时间
+func (r *DtsRecord) GetSourceTime() time.Time {
+	return time.Unix(r.SourceTimeStamp, 0)
+}
+
+// GetProcessTimestamps 获取记录在DTS数据流中被处理的时间戳列表(毫秒)
+func (r *DtsRecord) GetProcessTimestamps() []int64 {
+	items, ok := r.ProcessTimestamps["array"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	timestamps := make([]int64, 0, len(items))
+	for _, item := range items {
+		if ts, ok := item.(int64); ok {
+			timestamps = append(timestamps, ts)
+		}
+	}
+	return timestamps
+}
+
 // 解析一些东西
 func (r *DtsRecord) parse() error {
+	r.Phase = getPhase(r.Operation)
+
 	// 解析数据库名和表名
 	if r.ObjectName != nil {
-		name := r.ObjectName["string"]
-		tokens := strings.Split(name, ".")
-		switch len(tokens) {
-		case 1:
-			r.Database = tokens[0]
-		case 2:
-			r.Database = tokens[0]
-			r.Table = tokens[1]
-		}
+		r.Database, r.Table = splitObjectName(r.ObjectName["string"])
 	}
 
 	return nil
 }
 
+// splitObjectName 从objectName中解析出数据库名和表名
+func splitObjectName(name string) (string, string) {
+	tokens := strings.Split(name, ".")
+	switch len(tokens) {
+	case 1:
+		return tokens[0], ""
+	case 2:
+		return tokens[0], tokens[1]
+	}
+	return "", ""
+}
+
 // 获取ColValue
 func (r *DtsRecord) getColValue(kv interface{}) string {
 	if kv == nil {
@@ -99,33 +169,40 @@ func (r *DtsRecord) getColValue(kv interface{}) string {
 	ret := ""
 	for k, v := range mapValues {
 		switch k {
-		case "com.alibaba.alidts.formats.avro.Character",
-			"com.alibaba.alidts.formats.avro.BinaryGeometry":
+		case AVRO_TYPE_CHARACTER,
+			AVRO_TYPE_BINARY_GEOMETRY:
 			var vv DtsTypeBytes
 			err := mapstructure.Decode(v, &vv)
 			if err != nil {
 				return ""
 			}
 			ret = string(vv.Value)
-		case "com.alibaba.alidts.formats.avro.Integer",
-			"com.alibaba.alidts.formats.avro.Decimal",
-			"com.alibaba.alidts.formats.avro.Float",
-			"com.alibaba.alidts.formats.avro.TextGeometry",
-			"com.alibaba.alidts.formats.avro.TextObject":
+		case AVRO_TYPE_INTEGER,
+			AVRO_TYPE_DECIMAL,
+			AVRO_TYPE_TEXT_GEOMETRY,
+			AVRO_TYPE_TEXT_OBJECT:
 			var vv DtsTypeValue
 			err := mapstructure.Decode(v, &vv)
 			if err != nil {
 				return ""
 			}
 			ret = vv.Value
-		case "com.alibaba.alidts.formats.avro.Timestamp":
+		case AVRO_TYPE_FLOAT:
+			// Float的value是double, 不是字符串
+			var vv DtsTypeFloat
+			err := mapstructure.Decode(v, &vv)
+			if err != nil {
+				return ""
+			}
+			ret = strconv.FormatFloat(vv.Value, 'f', -1, 64)
+		case AVRO_TYPE_TIMESTAMP:
 			var vv DtsTypeTimestamp
 			err := mapstructure.Decode(v, &vv)
 			if err != nil {
 				return ""
 			}
 			ret = strconv.FormatInt(vv.Timestamp, 10)
-		case "com.alibaba.alidts.formats.avro.DateTime":
+		case AVRO_TYPE_DATETIME:
 			var vv DtsTypeDateTime
 			err := mapstructure.Decode(v, &vv)
 			if err != nil {
@@ -133,7 +210,7 @@ func (r *DtsRecord) getColValue(kv interface{}) string {
 			}
 			ret = fmt.Sprintf("%v-%v-%v %v:%v:%v",
 				vv.Year["int"], vv.Month["int"], vv.Day["int"], vv.Hour["int"], vv.Minute["int"], vv.Second["int"])
-		case "com.alibaba.alidts.formats.avro.TimestampWithTimeZone":
+		case AVRO_TYPE_TIMESTAMP_WITH_TIMEZONE:
 			var vv DtsTypeTimestampWithTimeZone
 			err := mapstructure.Decode(v, &vv)
 			if err != nil {
@@ -148,25 +225,15 @@ func (r *DtsRecord) getColValue(kv interface{}) string {
 }
 
 func (r *DtsRecord) getColumns(images map[string]interface{}) map[string]string {
-	imageArray := images["array"]
-	if imageArray == nil {
+	array, ok := getImageArray(images)
+	if !ok {
 		return nil
 	}
 
-	if len(r.TableFields) == 0 {
-		fields, err := r.getFields()
-		if err != nil {
-			return nil
-		}
-
-		if len(fields.Items) == 0 {
-			return nil
-		}
-
-		r.TableFields = fields.Items
+	if len(r.loadTableFields()) == 0 {
+		return nil
 	}
 
-	array := imageArray.([]interface{})
 	if len(r.TableFields) != len(array) {
 		return nil
 	}
@@ -184,6 +251,17 @@ func (r *DtsRecord) getColumns(images map[string]interface{}) map[string]string
 	return cols
 }
 
+// loadTableFields 确保TableFields已经从Fields中解析出来
+func (r *DtsRecord) loadTableFields() []*DtsField {
+	if len(r.TableFields) == 0 {
+		fields, err := r.getFields()
+		if err == nil && fields != nil {
+			r.TableFields = fields.Items
+		}
+	}
+	return r.TableFields
+}
+
 func (r *DtsRecord) getFields() (*DtsFields, error) {
 	var fields DtsFields
 	err := mapstructure.Decode(r.Fields, &fields)
@@ -193,3 +271,9 @@ func (r *DtsRecord) getFields() (*DtsFields, error) {
 
 	return &fields, nil
 }
+
+// getImageArray 获取镜像中的值数组, 镜像为null时返回false
+func getImageArray(images map[string]interface{}) ([]interface{}, bool) {
+	array, ok := images["array"].([]interface{})
+	return array, ok
+}
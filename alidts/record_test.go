@@ -0,0 +1,278 @@
+package alidts
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testOperations = []string{
+	OPERATION_INSERT, OPERATION_UPDATE, OPERATION_DELETE, OPERATION_DDL,
+	OPERATION_BEGIN, OPERATION_COMMIT, OPERATION_ROLLBACK, OPERATION_ABORT,
+	OPERATION_HEARTBEAT, OPERATION_CHECKPOINT, OPERATION_COMMAND, OPERATION_FILL,
+	OPERATION_FINISH, OPERATION_CONTROL, OPERATION_RDB, OPERATION_NOOP, OPERATION_INIT,
+}
+
+type testField struct {
+	name     string
+	dataType int
+}
+
+// testMessage 用于在测试中构造DTS的avro消息
+// 镜像中的值: nil表示NULL, string编码为Character, int64编码为Integer, float64编码为Float
+// before/after为nil时表示镜像为null
+type testMessage struct {
+	id                int64
+	sourceTimestamp   int64
+	txId              string
+	operation         string
+	objectName        string
+	processTimestamps []int64
+	tags              map[string]string
+	fields            []testField
+	before            []interface{}
+	after             []interface{}
+}
+
+type testWriter struct {
+	buf []byte
+}
+
+func (w *testWriter) long(v int64) {
+	u := uint64((v << 1) ^ (v >> 63))
+	for u >= 0x80 {
+		w.buf = append(w.buf, byte(u)|0x80)
+		u >>= 7
+	}
+	w.buf = append(w.buf, byte(u))
+}
+
+func (w *testWriter) str(s string) {
+	w.long(int64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *testWriter) double(f float64) {
+	bits := math.Float64bits(f)
+	for i := 0; i < 8; i++ {
+		w.buf = append(w.buf, byte(bits>>(8*i)))
+	}
+}
+
+func (w *testWriter) image(values []interface{}) {
+	if values == nil {
+		w.long(0)
+		return
+	}
+
+	w.long(2)
+	if len(values) > 0 {
+		w.long(int64(len(values)))
+		for _, v := range values {
+			switch vv := v.(type) {
+			case nil:
+				w.long(0)
+			case int64:
+				w.long(1)
+				w.long(20)
+				w.str(strconv.FormatInt(vv, 10))
+			case string:
+				w.long(2)
+				w.str("utf8mb4")
+				w.str(vv)
+			case float64:
+				w.long(4)
+				w.double(vv)
+				w.long(0)
+				w.long(0)
+			default:
+				panic("unsupported test value")
+			}
+		}
+	}
+	w.long(0)
+}
+
+func (m testMessage) encode() []byte {
+	w := &testWriter{}
+	w.long(0)
+	w.long(m.id)
+	w.long(m.sourceTimestamp)
+	w.str("mysql-bin.000001:100")
+	w.str("")
+	w.str(m.txId)
+	w.long(0)
+	w.str("5.7")
+
+	operation := -1
+	for i, op := range testOperations {
+		if op == m.operation {
+			operation = i
+		}
+	}
+	w.long(int64(operation))
+
+	if m.objectName == "" {
+		w.long(0)
+	} else {
+		w.long(1)
+		w.str(m.objectName)
+	}
+
+	if m.processTimestamps == nil {
+		w.long(0)
+	} else {
+		w.long(1)
+		w.long(int64(len(m.processTimestamps)))
+		for _, ts := range m.processTimestamps {
+			w.long(ts)
+		}
+		w.long(0)
+	}
+
+	if len(m.tags) > 0 {
+		w.long(int64(len(m.tags)))
+		for k, v := range m.tags {
+			w.str(k)
+			w.str(v)
+		}
+	}
+	w.long(0)
+
+	if m.fields == nil {
+		w.long(0)
+	} else {
+		w.long(2)
+		if len(m.fields) > 0 {
+			w.long(int64(len(m.fields)))
+			for _, f := range m.fields {
+				w.str(f.name)
+				w.long(int64(f.dataType))
+			}
+		}
+		w.long(0)
+	}
+
+	w.image(m.before)
+	w.image(m.after)
+	return w.buf
+}
+
+var testUserFields = []testField{
+	{name: "id", dataType: MYSQL_TYPE_INT64},
+	{name: "name", dataType: MYSQL_TYPE_VAR_STRING},
+	{name: "email", dataType: MYSQL_TYPE_VAR_STRING},
+}
+
+func newTestInsert(id int64, userId int64, name, email string) testMessage {
+	return testMessage{
+		id:              id,
+		sourceTimestamp: 1600000000 + id,
+		txId:            "tx" + strconv.FormatInt(id, 10),
+		operation:       OPERATION_INSERT,
+		objectName:      "shop.user",
+		tags:            map[string]string{"pk_uk_info": `{"PRIMARY":["id"]}`},
+		fields:          testUserFields,
+		after:           []interface{}{userId, name, email},
+	}
+}
+
+func parseTestMessage(t testing.TB, m testMessage) *DtsRecord {
+	ad, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ad.Parse(m.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestParse(t *testing.T) {
+	m := newTestInsert(1, 10, "tom", "tom@example.com")
+	m.processTimestamps = []int64{1600000001000, 1600000002000}
+	r := parseTestMessage(t, m)
+
+	assert.Equal(t, int64(1), r.Id)
+	assert.Equal(t, OPERATION_INSERT, r.Operation)
+	assert.Equal(t, "shop", r.Database)
+	assert.Equal(t, "user", r.Table)
+	assert.Equal(t, PHASE_INCREMENTAL, r.Phase)
+	assert.Equal(t, []int64{1600000001000, 1600000002000}, r.GetProcessTimestamps())
+	assert.Equal(t, map[string]string{"id": "10", "name": "tom", "email": "tom@example.com"}, r.GetAfterColumns())
+	assert.Nil(t, r.GetBeforeColumns())
+}
+
+func TestParseFloat(t *testing.T) {
+	m := newTestInsert(1, 10, "tom", "tom@example.com")
+	m.fields = []testField{{name: "id", dataType: MYSQL_TYPE_INT64}, {name: "price", dataType: MYSQL_TYPE_DOUBLE}}
+	m.after = []interface{}{int64(10), 12.5}
+	r := parseTestMessage(t, m)
+	assert.Equal(t, map[string]string{"id": "10", "price": "12.5"}, r.GetAfterColumns())
+
+	// 不使用科学计数法, NaN和Inf原样输出, NULL仍然是NULL
+	cases := []struct {
+		value    float64
+		expected string
+	}{
+		{-0.25, "-0.25"},
+		{1e21, "1000000000000000000000"},
+		{0.000001, "0.000001"},
+		{math.NaN(), "NaN"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+	}
+	for _, c := range cases {
+		m.operation = OPERATION_UPDATE
+		m.before = []interface{}{int64(10), c.value}
+		m.after = []interface{}{int64(10), nil}
+		r = parseTestMessage(t, m)
+		assert.Equal(t, c.expected, r.GetBeforeColumns()["price"])
+		assert.Nil(t, r.getNullableColumns(r.AfterImages)["price"])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := newTestInsert(1, 10, "tom", "tom@example.com")
+	assert.NoError(t, parseTestMessage(t, valid).Validate())
+
+	update := valid
+	update.operation = OPERATION_UPDATE
+	err := parseTestMessage(t, update).Validate()
+	if assert.IsType(t, &ValidationError{}, err) {
+		violations := err.(*ValidationError).Violations
+		assert.Len(t, violations, 1)
+		assert.Equal(t, RULE_BEFORE_IMAGE, violations[0].Rule)
+	}
+
+	broken := valid
+	broken.objectName = ""
+	broken.before = []interface{}{int64(10)}
+	broken.fields = append([]testField{}, testUserFields...)
+	broken.fields[1].dataType = 100
+	err = parseTestMessage(t, broken).Validate()
+	if assert.IsType(t, &ValidationError{}, err) {
+		rules := make([]string, 0)
+		for _, v := range err.(*ValidationError).Violations {
+			rules = append(rules, v.Rule)
+		}
+		assert.Equal(t, []string{RULE_OBJECT_NAME, RULE_DATA_TYPE, RULE_BEFORE_IMAGE, RULE_IMAGE_LENGTH}, rules)
+	}
+
+	heartbeat := testMessage{id: 2, operation: OPERATION_HEARTBEAT}
+	assert.NoError(t, parseTestMessage(t, heartbeat).Validate())
+}
+
+func TestParseWithValidation(t *testing.T) {
+	ad, err := New(WithValidation())
+	assert.NoError(t, err)
+
+	m := newTestInsert(1, 10, "tom", "tom@example.com")
+	m.after = m.after[:2]
+	_, err = ad.Parse(m.encode())
+	assert.IsType(t, &ValidationError{}, err)
+}
@@ -0,0 +1,214 @@
+package alidts
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ReplicaChange 副本中一行数据的变化, INSERT时Before为nil, DELETE时After为nil
+type ReplicaChange struct {
+	Database  string
+	Table     string
+	Key       string
+	Operation string
+	Before    map[string]string
+	After     map[string]string
+}
+
+// Replica 根据DTS数据流在内存中维护表的副本, 适合数据量较小的维表
+// 副本中的行是只读的, Get, Snapshot和Range返回的行不能被修改
+type Replica struct {
+	mu       sync.RWMutex
+	keyFunc  KeyFunc
+	tables   map[string]bool                         // 需要同步的表, 为空表示同步所有表
+	rows     map[string]map[string]map[string]string // 表名 -> 行的唯一键 -> 行
+	snapshot *SnapshotTracker
+
+	subMu       sync.RWMutex
+	subscribers map[int]func(ReplicaChange)
+	nextSubId   int
+}
+
+// NewReplica 创建副本, keyFunc为nil时使用PrimaryKey, tables为需要同步的"数据库名.表名", 为空时同步所有表
+func NewReplica(keyFunc KeyFunc, tables ...string) *Replica {
+	if keyFunc == nil {
+		keyFunc = PrimaryKey
+	}
+
+	r := &Replica{
+		keyFunc:     keyFunc,
+		tables:      make(map[string]bool),
+		rows:        make(map[string]map[string]map[string]string),
+		snapshot:    NewSnapshotTracker(),
+		subscribers: make(map[int]func(ReplicaChange)),
+	}
+	for _, table := range tables {
+		r.tables[table] = true
+	}
+	return r
+}
+
+// Apply 将一条记录应用到副本中
+// INSERT, FILL和UPDATE写入改变后的行, DELETE删除改变前的行, INIT表示重新开始全量初始化, 会清空该表
+func (r *Replica) Apply(record *DtsRecord) error {
+	if record == nil {
+		return nil
+	}
+
+	table := record.GetFullTableName()
+	if record.Table != "" && len(r.tables) > 0 && !r.tables[table] {
+		return nil
+	}
+
+	// 不带表名的FINISH也需要交给SnapshotTracker
+	r.snapshot.Apply(record)
+	if record.Table == "" {
+		return nil
+	}
+
+	var change *ReplicaChange
+	switch record.Operation {
+	case OPERATION_INIT:
+		r.mu.Lock()
+		delete(r.rows, table)
+		r.mu.Unlock()
+		return nil
+	case OPERATION_INSERT, OPERATION_FILL, OPERATION_UPDATE, OPERATION_DELETE:
+		var err error
+		change, err = r.applyRow(table, record)
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	r.notify(*change)
+	return nil
+}
+
+func (r *Replica) applyRow(table string, record *DtsRecord) (*ReplicaChange, error) {
+	key := r.keyFunc(record)
+	if key == "" {
+		return nil, errors.Errorf("can not get row key, table: %s, id: %d", table, record.Id)
+	}
+
+	change := &ReplicaChange{
+		Database:  record.Database,
+		Table:     record.Table,
+		Key:       key,
+		Operation: record.Operation,
+	}
+
+	var after map[string]string
+	if record.Operation != OPERATION_DELETE {
+		after = record.GetAfterColumns()
+		if after == nil {
+			return nil, errors.Errorf("missing after image, table: %s, id: %d", table, record.Id)
+		}
+	}
+
+	// UPDATE修改了唯一键时, 需要删除旧唯一键对应的行
+	var oldKey string
+	if record.Operation == OPERATION_UPDATE {
+		oldKey = record.GetBeforeKey(r.keyFunc)
+	}
+
+	r.mu.Lock()
+	rows, exist := r.rows[table]
+	if !exist {
+		rows = make(map[string]map[string]string)
+		r.rows[table] = rows
+	}
+
+	change.Before = rows[key]
+	if oldKey != "" && oldKey != key {
+		if old, exist := rows[oldKey]; exist {
+			change.Before = old
+			delete(rows, oldKey)
+		}
+	}
+
+	if after == nil {
+		delete(rows, key)
+	} else {
+		rows[key] = after
+	}
+	change.After = after
+	r.mu.Unlock()
+
+	return change, nil
+}
+
+// Get 根据唯一键获取一行
+func (r *Replica) Get(database, table, key string) (map[string]string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	row, exist := r.rows[database+"."+table][key]
+	return row, exist
+}
+
+// Len 表中的行数
+func (r *Replica) Len(database, table string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.rows[database+"."+table])
+}
+
+// Snapshot 获取表在当前时刻的一致性快照, 之后副本的变化不会影响返回的快照
+func (r *Replica) Snapshot(database, table string) map[string]map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rows := r.rows[database+"."+table]
+	snapshot := make(map[string]map[string]string, len(rows))
+	for key, row := range rows {
+		snapshot[key] = row
+	}
+	return snapshot
+}
+
+// Range 在表的一致性快照上遍历所有行, fn返回false时停止遍历
+func (r *Replica) Range(database, table string, fn func(key string, row map[string]string) bool) {
+	for key, row := range r.Snapshot(database, table) {
+		if !fn(key, row) {
+			return
+		}
+	}
+}
+
+// Ready 表的全量初始化是否已经完成, 完成前副本中的数据可能不完整
+func (r *Replica) Ready(database, table string) bool {
+	return r.snapshot.IsSnapshotFinished(database, table)
+}
+
+// Subscribe 订阅副本的变化, 回调在Apply中同步执行, 返回取消订阅的函数
+func (r *Replica) Subscribe(fn func(change ReplicaChange)) func() {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	id := r.nextSubId
+	r.nextSubId++
+	r.subscribers[id] = fn
+
+	return func() {
+		r.subMu.Lock()
+		defer r.subMu.Unlock()
+		delete(r.subscribers, id)
+	}
+}
+
+func (r *Replica) notify(change ReplicaChange) {
+	r.subMu.RLock()
+	subscribers := make([]func(ReplicaChange), 0, len(r.subscribers))
+	for _, fn := range r.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	r.subMu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(change)
+	}
+}
@@ -0,0 +1,99 @@
+package alidts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplica(t *testing.T) {
+	replica := NewReplica(nil, "shop.user")
+
+	changes := make([]ReplicaChange, 0)
+	unsubscribe := replica.Subscribe(func(change ReplicaChange) {
+		changes = append(changes, change)
+	})
+
+	other := newTestInsert(6, 10, "tom", "tom@example.com")
+	other.objectName = "shop.order"
+	for _, m := range append(newTestChanges(), other) {
+		assert.NoError(t, replica.Apply(parseTestMessage(t, m)))
+	}
+
+	// tom的唯一键从10改为11后被删除, 只剩下jerry, 没有同步的表被忽略
+	assert.Equal(t, 1, replica.Len("shop", "user"))
+	assert.Equal(t, 0, replica.Len("shop", "order"))
+	row, exist := replica.Get("shop", "user", "20")
+	assert.True(t, exist)
+	assert.Equal(t, map[string]string{"id": "20", "name": "jerry", "email": "jerry@example.com"}, row)
+	_, exist = replica.Get("shop", "user", "10")
+	assert.False(t, exist)
+
+	if assert.Len(t, changes, 5) {
+		assert.Equal(t, OPERATION_INSERT, changes[0].Operation)
+		assert.Nil(t, changes[0].Before)
+
+		// 修改唯一键时Before为旧唯一键对应的行
+		rekey := changes[3]
+		assert.Equal(t, "11", rekey.Key)
+		assert.Equal(t, "tommy", rekey.Before["name"])
+		assert.Equal(t, "10", rekey.Before["id"])
+		assert.Equal(t, "11", rekey.After["id"])
+
+		remove := changes[4]
+		assert.Equal(t, OPERATION_DELETE, remove.Operation)
+		assert.Equal(t, "11", remove.Before["id"])
+		assert.Nil(t, remove.After)
+	}
+
+	// 取消订阅后不再回调
+	unsubscribe()
+	assert.NoError(t, replica.Apply(parseTestMessage(t, newTestInsert(7, 30, "spike", "spike@example.com"))))
+	assert.Len(t, changes, 5)
+
+	snapshot := replica.Snapshot("shop", "user")
+	assert.NoError(t, replica.Apply(parseTestMessage(t, newTestInsert(8, 40, "tyke", "tyke@example.com"))))
+	assert.Len(t, snapshot, 2)
+
+	keys := make([]string, 0)
+	replica.Range("shop", "user", func(key string, row map[string]string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.ElementsMatch(t, []string{"20", "30", "40"}, keys)
+}
+
+func TestReplicaSnapshot(t *testing.T) {
+	replica := NewReplica(nil)
+
+	// INIT清空表并开始全量初始化
+	assert.NoError(t, replica.Apply(parseTestMessage(t, newTestInsert(1, 10, "tom", "tom@example.com"))))
+	assert.NoError(t, replica.Apply(parseTestMessage(t, testMessage{id: 2, operation: OPERATION_INIT, objectName: "shop.user"})))
+	assert.Equal(t, 0, replica.Len("shop", "user"))
+	assert.False(t, replica.Ready("shop", "user"))
+
+	fill := newTestInsert(3, 20, "jerry", "jerry@example.com")
+	fill.operation = OPERATION_FILL
+	assert.NoError(t, replica.Apply(parseTestMessage(t, fill)))
+	assert.Equal(t, 1, replica.Len("shop", "user"))
+	assert.False(t, replica.Ready("shop", "user"))
+
+	assert.NoError(t, replica.Apply(parseTestMessage(t, testMessage{id: 4, operation: OPERATION_FINISH, objectName: "shop.user"})))
+	assert.True(t, replica.Ready("shop", "user"))
+
+	// 不带表名的FINISH结束所有正在加载的表
+	order := newTestInsert(5, 30, "spike", "spike@example.com")
+	order.operation = OPERATION_FILL
+	order.objectName = "shop.order"
+	assert.NoError(t, replica.Apply(parseTestMessage(t, order)))
+	assert.False(t, replica.Ready("shop", "order"))
+	assert.NoError(t, replica.Apply(parseTestMessage(t, testMessage{id: 6, operation: OPERATION_FINISH})))
+	assert.True(t, replica.Ready("shop", "order"))
+
+	// 缺少改变后镜像的UPDATE返回错误
+	broken := newTestInsert(7, 20, "jerry", "jerry@example.com")
+	broken.operation = OPERATION_UPDATE
+	broken.before = broken.after
+	broken.after = nil
+	assert.Error(t, replica.Apply(parseTestMessage(t, broken)))
+}
@@ -0,0 +1,34 @@
+package alidts
+
+// keyRing 固定容量的环形缓冲区, 按先后顺序保存最近加入的key, 满了之后淘汰最早的key
+// 不是并发安全的, 由使用者加锁
+type keyRing struct {
+	keys []string
+	next int // 下一个写入位置
+}
+
+func newKeyRing(size int) *keyRing {
+	if size <= 0 {
+		size = 1
+	}
+	return &keyRing{keys: make([]string, size)}
+}
+
+// add 加入key, 返回被淘汰的key, 没有淘汰时返回空字符串
+func (r *keyRing) add(key string) string {
+	old := r.keys[r.next]
+	r.keys[r.next] = key
+	r.next = (r.next + 1) % len(r.keys)
+	return old
+}
+
+// ordered 按照加入的先后顺序返回所有的key
+func (r *keyRing) ordered() []string {
+	keys := make([]string, 0, len(r.keys))
+	for i := range r.keys {
+		if key := r.keys[(r.next+i)%len(r.keys)]; key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
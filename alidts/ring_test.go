@@ -0,0 +1,16 @@
+package alidts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRing(t *testing.T) {
+	ring := newKeyRing(2)
+	assert.Equal(t, "", ring.add("a"))
+	assert.Equal(t, "", ring.add("b"))
+	assert.Equal(t, []string{"a", "b"}, ring.ordered())
+	assert.Equal(t, "a", ring.add("c"))
+	assert.Equal(t, []string{"b", "c"}, ring.ordered())
+}
@@ -1,44 +1,134 @@
 package alidts
 
 import (
+	"sync"
+	"time"
+
 	"github.com/hamba/avro"
-	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
 )
 
 type AliDts struct {
-	schema avro.Schema
+	schema     *dtsSchema
+	registry   *SchemaRegistry
+	validate   bool
+	metrics    *Metrics
+	readerPool sync.Pool
+}
+
+// Option AliDts的可选配置
+type Option func(*AliDts)
+
+// WithValidation 解析后对记录做结构校验, 校验失败时Parse返回*ValidationError
+func WithValidation() Option {
+	return func(ad *AliDts) {
+		ad.validate = true
+	}
+}
+
+// WithSchemaRegistry 根据消息开头的version从注册表中选择schema, 没有对应的schema时返回*UnknownSchemaVersionError
+func WithSchemaRegistry(registry *SchemaRegistry) Option {
+	return func(ad *AliDts) {
+		ad.registry = registry
+	}
+}
+
+// New 使用ALIYUN_DTS_SCHEMA解析所有消息
+func New(options ...Option) (*AliDts, error) {
+	return NewWithSchema(ALIYUN_DTS_SCHEMA, options...)
 }
 
-func New() (*AliDts, error) {
-	s, err := avro.Parse(ALIYUN_DTS_SCHEMA)
+// NewWithSchema 使用指定的schema解析所有消息, 配置了WithSchemaRegistry时schema不会被使用
+func NewWithSchema(schema string, options ...Option) (*AliDts, error) {
+	s, err := parseDtsSchema(schema)
 	if err != nil {
 		return nil, err
 	}
 
-	return &AliDts{
+	ad := &AliDts{
 		schema: s,
-	}, nil
+	}
+	ad.readerPool.New = func() interface{} {
+		return avro.NewReader(nil, 0)
+	}
+
+	for _, option := range options {
+		option(ad)
+	}
+
+	return ad, nil
 }
 
 // Parse 解析DTS的消息记录
 func (ad *AliDts) Parse(data []byte) (*DtsRecord, error) {
-	var v interface{}
-
-	err := avro.Unmarshal(ad.schema, data, &v)
+	var r DtsRecord
+	err := ad.ParseInto(data, &r)
 	if err != nil {
 		return nil, err
 	}
 
-	var r DtsRecord
-	err = mapstructure.Decode(v, &r)
+	return &r, nil
+}
+
+// ParseInto 将DTS的消息记录解析到r中, r原有的内容会被清空, ObjectName, Tags和TableFields会被复用,
+// Fields, ProcessTimestamps和镜像由avro解码器每次重新分配
+// 配合AcquireRecord和Release使用可以减少高吞吐场景下的内存分配
+func (ad *AliDts) ParseInto(data []byte, r *DtsRecord) error {
+	if ad.metrics == nil {
+		return ad.parseInto(data, r)
+	}
+
+	start := time.Now()
+	err := ad.parseInto(data, r)
+	ad.metrics.observeParse(data, r, err, time.Since(start))
+	return err
+}
+
+func (ad *AliDts) parseInto(data []byte, r *DtsRecord) error {
+	r.reset()
+
+	schema, err := ad.lookupSchema(data)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	reader := ad.acquireReader(data)
+	defer ad.readerPool.Put(reader)
+
+	for _, field := range schema.fields {
+		v := reader.ReadNext(field.Type())
+		if reader.Error != nil {
+			return errors.Wrapf(reader.Error, "read field %s", field.Name())
+		}
+
+		r.setField(field.Name(), v)
 	}
 
 	err = r.parse()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &r, nil
+	if ad.validate {
+		err = r.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lookupSchema 获取解析消息使用的schema
+func (ad *AliDts) lookupSchema(data []byte) (*dtsSchema, error) {
+	if ad.registry == nil {
+		return ad.schema, nil
+	}
+	return ad.registry.lookup(data)
+}
+
+func (ad *AliDts) acquireReader(data []byte) *avro.Reader {
+	reader := ad.readerPool.Get().(*avro.Reader)
+	reader.Error = nil
+	return reader.Reset(data)
 }
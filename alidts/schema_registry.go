@@ -0,0 +1,126 @@
+package alidts
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hamba/avro"
+	"github.com/pkg/errors"
+)
+
+// ALIYUN_DTS_SCHEMA_VERSION ALIYUN_DTS_SCHEMA对应的version字段的值
+const ALIYUN_DTS_SCHEMA_VERSION = 0
+
+// UnknownSchemaVersionError 消息的version没有对应的schema
+type UnknownSchemaVersionError struct {
+	Version int
+	Known   []int // 已经注册的version
+}
+
+func (e *UnknownSchemaVersionError) Error() string {
+	return fmt.Sprintf("unknown dts schema version: %d, known versions: %v", e.Version, e.Known)
+}
+
+// dtsSchema 解析后的schema
+type dtsSchema struct {
+	schema avro.Schema
+	fields []*avro.Field
+}
+
+// parseDtsSchema 解析schema, schema必须是record, 并且第一个字段为int类型的version
+func parseDtsSchema(schema string) (*dtsSchema, error) {
+	s, err := avro.Parse(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	recordSchema, ok := s.(*avro.RecordSchema)
+	if !ok {
+		return nil, errors.New("dts schema is not a record")
+	}
+
+	fields := recordSchema.Fields()
+	if len(fields) == 0 || fields[0].Name() != "version" || fields[0].Type().Type() != avro.Int {
+		return nil, errors.New("the first field of dts schema must be the int field version")
+	}
+
+	return &dtsSchema{schema: s, fields: fields}, nil
+}
+
+// SchemaRegistry 按version保存不同版本的DTS schema
+// 所有版本的消息都以version字段开头, 解析时根据消息开头的version选择schema
+type SchemaRegistry struct {
+	mu      sync.RWMutex
+	schemas map[int]*dtsSchema
+}
+
+// NewSchemaRegistry 创建注册表, 默认包含ALIYUN_DTS_SCHEMA
+func NewSchemaRegistry() *SchemaRegistry {
+	sr := &SchemaRegistry{
+		schemas: make(map[int]*dtsSchema),
+	}
+
+	err := sr.Register(ALIYUN_DTS_SCHEMA_VERSION, ALIYUN_DTS_SCHEMA)
+	if err != nil {
+		panic(err)
+	}
+	return sr
+}
+
+// Register 注册version对应的schema, 已经注册的version会被覆盖
+func (sr *SchemaRegistry) Register(version int, schema string) error {
+	s, err := parseDtsSchema(schema)
+	if err != nil {
+		return errors.Wrapf(err, "register dts schema version %d", version)
+	}
+
+	sr.mu.Lock()
+	sr.schemas[version] = s
+	sr.mu.Unlock()
+	return nil
+}
+
+// Versions 获取所有已经注册的version, 从小到大排列
+func (sr *SchemaRegistry) Versions() []int {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	versions := make([]int, 0, len(sr.schemas))
+	for version := range sr.schemas {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// lookup 获取消息对应的schema
+func (sr *SchemaRegistry) lookup(data []byte) (*dtsSchema, error) {
+	version, err := ProbeVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sr.mu.RLock()
+	s, exist := sr.schemas[version]
+	sr.mu.RUnlock()
+	if !exist {
+		return nil, &UnknownSchemaVersionError{Version: version, Known: sr.Versions()}
+	}
+	return s, nil
+}
+
+// ProbeVersion 读取消息开头的version字段, 不需要解码整条消息
+// version是zigzag编码的int, 小于64的version只占用第一个字节
+func ProbeVersion(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, errors.New("probe dts schema version: empty message")
+	}
+
+	reader := avro.NewReader(nil, 0).Reset(data)
+	version := reader.ReadInt()
+	if reader.Error != nil {
+		return 0, errors.Wrap(reader.Error, "probe dts schema version")
+	}
+	return int(version), nil
+}
@@ -0,0 +1,53 @@
+package alidts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaRegistry(t *testing.T) {
+	registry := NewSchemaRegistry()
+	assert.NoError(t, registry.Register(1, ALIYUN_DTS_SCHEMA))
+	assert.Error(t, registry.Register(2, `{"type": "record", "name": "r", "fields": [{"name": "id", "type": "long"}]}`))
+	assert.Equal(t, []int{0, 1}, registry.Versions())
+
+	ad, err := New(WithSchemaRegistry(registry))
+	assert.NoError(t, err)
+
+	data := newTestInsert(1, 10, "tom", "tom@example.com").encode()
+	version, err := ProbeVersion(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	// version是zigzag编码, 1编码为2
+	data[0] = 2
+	r, err := ad.Parse(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, r.Version)
+		assert.Equal(t, "shop.user", r.GetFullTableName())
+	}
+
+	data[0] = 10
+	_, err = ad.Parse(data)
+	if assert.IsType(t, &UnknownSchemaVersionError{}, err) {
+		assert.Equal(t, 5, err.(*UnknownSchemaVersionError).Version)
+		assert.Equal(t, "unknown dts schema version: 5, known versions: [0 1]", err.Error())
+	}
+	_, err = ad.ParseHeader(data)
+	assert.IsType(t, &UnknownSchemaVersionError{}, err)
+
+	_, err = ProbeVersion(nil)
+	assert.Error(t, err)
+}
+
+func TestNewWithSchema(t *testing.T) {
+	_, err := NewWithSchema(`{"type": "string"}`)
+	assert.Error(t, err)
+
+	ad, err := NewWithSchema(ALIYUN_DTS_SCHEMA)
+	assert.NoError(t, err)
+	r, err := ad.Parse(newTestInsert(1, 10, "tom", "tom@example.com").encode())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), r.Id)
+}
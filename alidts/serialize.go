@@ -0,0 +1,160 @@
+package alidts
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxSerializedValueBytes MarshalJSON和String中BLOB/TEXT/JSON/GEOMETRY类型的值超过该长度时会被截断, <=0表示不截断
+var MaxSerializedValueBytes = 256
+
+// recordJSON DtsRecord的JSON格式, 字段顺序固定
+type recordJSON struct {
+	Id              int64                  `json:"id"`
+	Version         int                    `json:"version"`
+	SourceTimeStamp int64                  `json:"ts"`
+	SourceTxId      string                 `json:"tx,omitempty"`
+	Operation       string                 `json:"op"`
+	Table           string                 `json:"table,omitempty"`
+	Before          map[string]interface{} `json:"before,omitempty"`
+	After           map[string]interface{} `json:"after,omitempty"`
+	Changed         []string               `json:"changed,omitempty"`
+}
+
+// MarshalJSON 输出紧凑的JSON, 镜像中的数字输出为JSON数字, NULL输出为null, 过长的值会被截断
+func (r *DtsRecord) MarshalJSON() ([]byte, error) {
+	v := recordJSON{
+		Id:              r.Id,
+		Version:         r.Version,
+		SourceTimeStamp: r.SourceTimeStamp,
+		SourceTxId:      r.SourceTxId,
+		Operation:       r.Operation,
+		Table:           r.GetFullTableName(),
+		Changed:         r.changedColumnsForLog(),
+	}
+
+	for _, image := range []struct {
+		images map[string]interface{}
+		target *map[string]interface{}
+	}{{r.BeforeImages, &v.Before}, {r.AfterImages, &v.After}} {
+		cols := r.getNullableColumns(image.images)
+		if cols == nil {
+			continue
+		}
+
+		values := make(map[string]interface{}, len(cols))
+		for _, field := range r.TableFields {
+			if field == nil {
+				continue
+			}
+			values[field.Name] = typedLogValue(field.DataType, cols[field.Name])
+		}
+		*image.target = values
+	}
+
+	return json.Marshal(v)
+}
+
+// String 输出logfmt格式, 列按照字段顺序输出, 例如
+// id=1 version=0 ts=1600000001 tx=tx1 op=UPDATE table=shop.user changed=name before.id=10 before.name=tom after.id=10 after.name=tommy
+func (r *DtsRecord) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "id=%d version=%d ts=%d", r.Id, r.Version, r.SourceTimeStamp)
+	if r.SourceTxId != "" {
+		b.WriteString(" tx=" + logfmtValue(r.SourceTxId))
+	}
+	b.WriteString(" op=" + logfmtValue(r.Operation))
+	if table := r.GetFullTableName(); table != "" {
+		b.WriteString(" table=" + logfmtValue(table))
+	}
+	if changed := r.changedColumnsForLog(); len(changed) > 0 {
+		b.WriteString(" changed=" + logfmtValue(strings.Join(changed, ",")))
+	}
+
+	for _, image := range []struct {
+		prefix string
+		images map[string]interface{}
+	}{{"before.", r.BeforeImages}, {"after.", r.AfterImages}} {
+		cols := r.getNullableColumns(image.images)
+		for _, field := range r.TableFields {
+			if cols == nil || field == nil {
+				continue
+			}
+
+			b.WriteString(" " + image.prefix + field.Name + "=")
+			switch v := typedLogValue(field.DataType, cols[field.Name]).(type) {
+			case nil:
+				b.WriteString("null")
+			case json.Number:
+				b.WriteString(string(v))
+			case string:
+				b.WriteString(logfmtValue(v))
+			}
+		}
+	}
+	return b.String()
+}
+
+// changedColumnsForLog 只有UPDATE输出变化的列, INSERT和DELETE的变化列就是所有列
+func (r *DtsRecord) changedColumnsForLog() []string {
+	if r.Operation != OPERATION_UPDATE {
+		return nil
+	}
+	return r.GetChangedColumns()
+}
+
+// jsonNumberPattern JSON的数字语法, strconv.ParseFloat还接受NaN, +Inf, .5和+5, json.Number无法输出这些值
+var jsonNumberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// typedLogValue 将列值按照字段类型转换, 数字类型为json.Number, NaN, Inf等不符合JSON数字语法的值为字符串, NULL为nil
+func typedLogValue(dataType int, value *string) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	switch dataType {
+	case MYSQL_TYPE_INT8, MYSQL_TYPE_INT16, MYSQL_TYPE_INT24, MYSQL_TYPE_INT32, MYSQL_TYPE_INT64, MYSQL_TYPE_YEAR,
+		MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE:
+		if jsonNumberPattern.MatchString(*value) {
+			return json.Number(*value)
+		}
+	case MYSQL_TYPE_TINY_BLOB, MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB,
+		MYSQL_TYPE_JSON, MYSQL_TYPE_GEOMETRY:
+		return truncateValue(*value, MaxSerializedValueBytes)
+	}
+	return *value
+}
+
+// truncateValue 截断超过max字节的值, 不会截断在多字节字符的中间, 并在末尾标注原始长度
+func truncateValue(value string, max int) string {
+	if max <= 0 || len(value) <= max {
+		return value
+	}
+
+	end := max
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+	return fmt.Sprintf("%s...(%d bytes)", value[:end], len(value))
+}
+
+// logfmtValue 值为空或者包含空格, 引号, 等号和控制字符时加上引号
+func logfmtValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	if value == "null" {
+		return strconv.Quote(value)
+	}
+
+	for _, c := range value {
+		if c <= ' ' || c == '"' || c == '=' || c == '\\' || c == utf8.RuneError || c == 0x7f {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,69 @@
+package alidts
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordSerialize(t *testing.T) {
+	m := newTestChanges()[1]
+	m.after[2] = nil
+	r := parseTestMessage(t, m)
+
+	data, err := json.Marshal(r)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":2,"version":0,"ts":1600000002,"tx":"tx2","op":"UPDATE","table":"shop.user",`+
+		`"before":{"email":"tom@example.com","id":10,"name":"tom"},"after":{"email":null,"id":10,"name":"tommy"},`+
+		`"changed":["name","email"]}`, string(data))
+
+	assert.Equal(t, `id=2 version=0 ts=1600000002 tx=tx2 op=UPDATE table=shop.user changed=name,email `+
+		`before.id=10 before.name=tom before.email=tom@example.com after.id=10 after.name=tommy after.email=null`, r.String())
+
+	heartbeat := parseTestMessage(t, testMessage{id: 3, operation: OPERATION_HEARTBEAT})
+	assert.Equal(t, "id=3 version=0 ts=0 op=HEARTBEAT", heartbeat.String())
+}
+
+func TestRecordSerializeTruncate(t *testing.T) {
+	m := newTestInsert(1, 10, "tom cat", strings.Repeat("中", 100))
+	m.fields = append([]testField{}, testUserFields...)
+	m.fields[2].dataType = MYSQL_TYPE_BLOB
+	r := parseTestMessage(t, m)
+
+	var v struct {
+		After map[string]interface{} `json:"after"`
+	}
+	data, err := json.Marshal(r)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &v))
+	assert.Equal(t, strings.Repeat("中", 85)+"...(300 bytes)", v.After["email"])
+	assert.Contains(t, r.String(), `after.name="tom cat"`)
+}
+
+func TestRecordSerializeNonFinite(t *testing.T) {
+	m := newTestInsert(1, 10, "tom", "tom@example.com")
+	m.fields = []testField{
+		{name: "id", dataType: MYSQL_TYPE_INT64},
+		{name: "nan", dataType: MYSQL_TYPE_DOUBLE},
+		{name: "inf", dataType: MYSQL_TYPE_DOUBLE},
+		{name: "price", dataType: MYSQL_TYPE_DOUBLE},
+	}
+	m.after = []interface{}{int64(10), math.NaN(), math.Inf(-1), 0.5}
+	r := parseTestMessage(t, m)
+
+	// NaN和Inf不是合法的JSON数字, 输出为字符串
+	data, err := json.Marshal(r)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"after":{"id":10,"inf":"-Inf","nan":"NaN","price":0.5}`)
+	assert.Contains(t, r.String(), "after.nan=NaN after.inf=-Inf after.price=0.5")
+
+	for _, value := range []string{"NaN", "+Inf", ".5", "+5", "5.", "01", "1e", "-"} {
+		assert.Equal(t, value, typedLogValue(MYSQL_TYPE_NEWDECIMAL, &value), value)
+	}
+	for _, value := range []string{"0", "-0.5", "12", "1e+06", "1.5E-3"} {
+		assert.Equal(t, json.Number(value), typedLogValue(MYSQL_TYPE_NEWDECIMAL, &value), value)
+	}
+}
@@ -0,0 +1,260 @@
+package alidts
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const changeStatsDefaultTopColumns = 10
+
+// ColumnCount 列被修改的次数
+type ColumnCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// TableStats 一张表在窗口内的写入统计
+type TableStats struct {
+	Database   string           `json:"database"`
+	Table      string           `json:"table"`
+	Operations map[string]int64 `json:"operations"`
+	Rows       int64            `json:"rows"`       // 修改的行数, 只统计INSERT, UPDATE, DELETE和FILL
+	Bytes      int64            `json:"bytes"`      // 镜像中列值的字节数
+	TopColumns []ColumnCount    `json:"topColumns"` // 修改次数最多的列
+}
+
+// StatsSnapshot 窗口的统计快照, Tables按照表名排序
+type StatsSnapshot struct {
+	Start  time.Time     `json:"start"`
+	End    time.Time     `json:"end"`
+	Tables []*TableStats `json:"tables"`
+}
+
+// statsBucket 一个滑动步长内的统计
+type statsBucket struct {
+	start  time.Time
+	tables map[string]*tableBucket
+}
+
+type tableBucket struct {
+	database   string
+	table      string
+	operations map[string]int64
+	rows       int64
+	bytes      int64
+	columns    map[string]int64
+}
+
+// ChangeStats 按窗口统计每张表的写入情况, 时间使用消费记录时的本地时间
+// slide等于size时为滚动窗口, 小于size时为滑动窗口, 窗口每隔slide向前移动一次
+type ChangeStats struct {
+	mu      sync.Mutex
+	size    time.Duration
+	slide   time.Duration
+	buckets []*statsBucket
+	now     func() time.Time
+
+	// TopColumns 每张表统计的修改次数最多的列的数量
+	TopColumns int
+	// OnWindow 窗口向前移动时, 使用刚结束的窗口的快照调用, 可以用来导出统计
+	OnWindow func(snapshot *StatsSnapshot)
+}
+
+// NewChangeStats 创建统计, size为窗口大小, slide为滑动步长, slide<=0或者大于size时为滚动窗口
+func NewChangeStats(size, slide time.Duration) *ChangeStats {
+	if slide <= 0 || slide > size {
+		slide = size
+	}
+
+	return &ChangeStats{
+		size:       size,
+		slide:      slide,
+		buckets:    make([]*statsBucket, 0),
+		now:        time.Now,
+		TopColumns: changeStatsDefaultTopColumns,
+	}
+}
+
+// Add 统计一条记录, 没有库名的记录会被忽略
+func (s *ChangeStats) Add(r *DtsRecord) {
+	if r == nil || r.Database == "" {
+		return
+	}
+
+	var columns []string
+	var size int64
+	isRow := false
+	switch r.Operation {
+	case OPERATION_INSERT, OPERATION_UPDATE, OPERATION_DELETE, OPERATION_FILL:
+		isRow = true
+		columns = r.GetChangedColumns()
+		for _, images := range []map[string]interface{}{r.BeforeImages, r.AfterImages} {
+			for _, v := range r.getNullableColumns(images) {
+				if v != nil {
+					size += int64(len(*v))
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	bucket, closed := s.advance(s.now())
+
+	name := r.GetFullTableName()
+	t, exist := bucket.tables[name]
+	if !exist {
+		t = &tableBucket{
+			database:   r.Database,
+			table:      r.Table,
+			operations: make(map[string]int64),
+			columns:    make(map[string]int64),
+		}
+		bucket.tables[name] = t
+	}
+	t.operations[r.Operation]++
+	if isRow {
+		t.rows++
+	}
+	t.bytes += size
+	for _, column := range columns {
+		t.columns[column]++
+	}
+	s.mu.Unlock()
+
+	s.notify(closed)
+}
+
+// Snapshot 获取当前窗口的统计, 当前窗口包含正在进行中的步长
+func (s *ChangeStats) Snapshot() *StatsSnapshot {
+	s.mu.Lock()
+	bucket, closed := s.advance(s.now())
+	snapshot := s.snapshot(bucket.start.Add(s.slide))
+	s.mu.Unlock()
+
+	s.notify(closed)
+	return snapshot
+}
+
+// Table 获取一张表在当前窗口的统计, table为"数据库名.表名", 没有写入时返回nil
+func (s *ChangeStats) Table(table string) *TableStats {
+	for _, t := range s.Snapshot().Tables {
+		if t.Database+"."+t.Table == table || (t.Table == "" && t.Database == table) {
+			return t
+		}
+	}
+	return nil
+}
+
+// WriteSnapshot 将当前窗口的统计以JSON格式写入w
+func (s *ChangeStats) WriteSnapshot(w io.Writer) error {
+	return errors.Wrap(json.NewEncoder(w).Encode(s.Snapshot()), "encode stats snapshot")
+}
+
+// advance 将窗口移动到now所在的步长, 返回当前步长的统计, 以及窗口移动时刚结束的窗口的快照
+func (s *ChangeStats) advance(now time.Time) (*statsBucket, *StatsSnapshot) {
+	start := now.Truncate(s.slide)
+	if n := len(s.buckets); n > 0 && !s.buckets[n-1].start.Before(start) {
+		return s.buckets[n-1], nil
+	}
+
+	var closed *StatsSnapshot
+	if n := len(s.buckets); n > 0 && s.OnWindow != nil {
+		closed = s.snapshot(s.buckets[n-1].start.Add(s.slide))
+	}
+
+	// 丢弃已经不在窗口内的步长
+	expired := 0
+	for _, bucket := range s.buckets {
+		if bucket.start.Add(s.size).After(start) {
+			break
+		}
+		expired++
+	}
+	s.buckets = append(s.buckets[:0], s.buckets[expired:]...)
+
+	bucket := &statsBucket{start: start, tables: make(map[string]*tableBucket)}
+	s.buckets = append(s.buckets, bucket)
+	return bucket, closed
+}
+
+// snapshot 合并结束时间为end的窗口内所有步长的统计
+func (s *ChangeStats) snapshot(end time.Time) *StatsSnapshot {
+	snapshot := &StatsSnapshot{
+		Start:  end.Add(-s.size),
+		End:    end,
+		Tables: make([]*TableStats, 0),
+	}
+
+	tables := make(map[string]*TableStats)
+	columns := make(map[string]map[string]int64)
+	for _, bucket := range s.buckets {
+		if bucket.start.Before(snapshot.Start) || !bucket.start.Before(end) {
+			continue
+		}
+
+		for name, t := range bucket.tables {
+			stats, exist := tables[name]
+			if !exist {
+				stats = &TableStats{
+					Database:   t.database,
+					Table:      t.table,
+					Operations: make(map[string]int64),
+				}
+				tables[name] = stats
+				columns[name] = make(map[string]int64)
+				snapshot.Tables = append(snapshot.Tables, stats)
+			}
+
+			for op, count := range t.operations {
+				stats.Operations[op] += count
+			}
+			stats.Rows += t.rows
+			stats.Bytes += t.bytes
+			for column, count := range t.columns {
+				columns[name][column] += count
+			}
+		}
+	}
+
+	for name, stats := range tables {
+		stats.TopColumns = topColumns(columns[name], s.TopColumns)
+	}
+	sort.Slice(snapshot.Tables, func(i, j int) bool {
+		a, b := snapshot.Tables[i], snapshot.Tables[j]
+		if a.Database != b.Database {
+			return a.Database < b.Database
+		}
+		return a.Table < b.Table
+	})
+	return snapshot
+}
+
+func (s *ChangeStats) notify(closed *StatsSnapshot) {
+	if closed != nil && s.OnWindow != nil {
+		s.OnWindow(closed)
+	}
+}
+
+// topColumns 按照修改次数从多到少排序, 次数相同时按照列名排序
+func topColumns(columns map[string]int64, n int) []ColumnCount {
+	counts := make([]ColumnCount, 0, len(columns))
+	for name, count := range columns {
+		counts = append(counts, ColumnCount{Name: name, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
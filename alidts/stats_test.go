@@ -0,0 +1,60 @@
+package alidts
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeStats(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	stats := NewChangeStats(3*time.Minute, time.Minute)
+	stats.now = func() time.Time { return now }
+	closed := make([]*StatsSnapshot, 0)
+	stats.OnWindow = func(snapshot *StatsSnapshot) {
+		closed = append(closed, snapshot)
+	}
+
+	records := make([]*DtsRecord, 0)
+	for _, m := range newTestChanges() {
+		records = append(records, parseTestMessage(t, m))
+	}
+
+	stats.Add(records[0])
+	stats.Add(records[1])
+	now = now.Add(time.Minute)
+	stats.Add(records[2])
+	stats.Add(records[3])
+	assert.Len(t, closed, 1)
+
+	table := stats.Table("shop.user")
+	if assert.NotNil(t, table) {
+		assert.Equal(t, map[string]int64{OPERATION_INSERT: 2, OPERATION_UPDATE: 2}, table.Operations)
+		assert.Equal(t, int64(4), table.Rows)
+		assert.Equal(t, []ColumnCount{{Name: "id", Count: 3}, {Name: "name", Count: 3}, {Name: "email", Count: 2}}, table.TopColumns)
+		assert.True(t, table.Bytes > 0)
+	}
+
+	// 第一个步长移出窗口
+	now = now.Add(2 * time.Minute)
+	stats.Add(records[4])
+	table = stats.Table("shop.user")
+	assert.Equal(t, map[string]int64{OPERATION_INSERT: 1, OPERATION_UPDATE: 1, OPERATION_DELETE: 1}, table.Operations)
+
+	var buf bytes.Buffer
+	assert.NoError(t, stats.WriteSnapshot(&buf))
+	var snapshot StatsSnapshot
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &snapshot))
+	assert.Len(t, snapshot.Tables, 1)
+	assert.Equal(t, int64(3), snapshot.Tables[0].Rows)
+
+	// 滚动窗口
+	tumbling := NewChangeStats(time.Minute, 0)
+	tumbling.now = func() time.Time { return now }
+	tumbling.Add(records[0])
+	now = now.Add(time.Minute)
+	assert.Nil(t, tumbling.Table("shop.user"))
+}
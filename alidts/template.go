@@ -0,0 +1,57 @@
+package alidts
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checkTemplate 检查模板中的{name}占位符是否成对出现
+func checkTemplate(tpl string) error {
+	open := false
+	for i, c := range tpl {
+		switch c {
+		case '{':
+			if open {
+				return errors.Errorf("nested '{' at %d in template: %s", i, tpl)
+			}
+			open = true
+		case '}':
+			if !open {
+				return errors.Errorf("unexpected '}' at %d in template: %s", i, tpl)
+			}
+			open = false
+		}
+	}
+	if open {
+		return errors.Errorf("unclosed '{' in template: %s", tpl)
+	}
+	return nil
+}
+
+// renderTemplate 用lookup查到的值替换模板中的{name}占位符, 任何一个占位符没有值时返回false
+func renderTemplate(tpl string, lookup func(name string) (string, bool)) (string, bool) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(tpl, '{')
+		if start < 0 {
+			b.WriteString(tpl)
+			return b.String(), true
+		}
+
+		end := strings.IndexByte(tpl[start:], '}')
+		if end < 0 {
+			return "", false
+		}
+		end += start
+
+		value, ok := lookup(tpl[start+1 : end])
+		if !ok {
+			return "", false
+		}
+
+		b.WriteString(tpl[:start])
+		b.WriteString(value)
+		tpl = tpl[end+1:]
+	}
+}
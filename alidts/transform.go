@@ -0,0 +1,248 @@
+package alidts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// REDACTED_VALUE RedactMasker替换后的值
+const REDACTED_VALUE = "******"
+
+// Transform 对解析后的记录做变换, 变换会直接修改记录
+// 返回false表示该记录被过滤掉, 不需要再继续处理
+type Transform func(r *DtsRecord) (bool, error)
+
+// Masker 对列的值做脱敏
+type Masker func(value string) string
+
+// Chain 将多个变换按顺序组合成一个变换, 任何一个变换过滤掉记录或者出错都会终止后续的变换
+func Chain(transforms ...Transform) Transform {
+	return func(r *DtsRecord) (bool, error) {
+		for _, transform := range transforms {
+			keep, err := transform(r)
+			if err != nil || !keep {
+				return keep, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// RenameColumn 重命名列
+func RenameColumn(from, to string) Transform {
+	return func(r *DtsRecord) (bool, error) {
+		index := r.fieldIndex(from)
+		if index < 0 {
+			return true, nil
+		}
+		if r.fieldIndex(to) >= 0 {
+			return false, errors.Errorf("rename column %s: column %s already exists", from, to)
+		}
+
+		fields := copyTableFields(r.TableFields)
+		fields[index].Name = to
+		r.setTableFields(fields)
+		return true, nil
+	}
+}
+
+// DropColumns 删除列, 例如密码等敏感列
+func DropColumns(columns ...string) Transform {
+	return func(r *DtsRecord) (bool, error) {
+		for _, column := range columns {
+			index := r.fieldIndex(column)
+			if index < 0 {
+				continue
+			}
+
+			err := r.eachImage(func(array []interface{}) ([]interface{}, error) {
+				if index >= len(array) {
+					return array, nil
+				}
+				newArray := make([]interface{}, 0, len(array)-1)
+				newArray = append(newArray, array[:index]...)
+				return append(newArray, array[index+1:]...), nil
+			})
+			if err != nil {
+				return false, err
+			}
+
+			fields := make([]*DtsField, 0, len(r.TableFields)-1)
+			fields = append(fields, r.TableFields[:index]...)
+			r.setTableFields(append(fields, r.TableFields[index+1:]...))
+		}
+		return true, nil
+	}
+}
+
+// MaskColumn 对列的值做脱敏, 脱敏后列的类型变为字符串, NULL值保持不变
+func MaskColumn(column string, masker Masker) Transform {
+	return func(r *DtsRecord) (bool, error) {
+		index := r.fieldIndex(column)
+		if index < 0 {
+			return true, nil
+		}
+
+		r.maskValue(index, masker)
+		return true, nil
+	}
+}
+
+// CastColumn 将列的值转换成指定的mysql类型, 值无法转换时返回错误
+func CastColumn(column string, dataType int) Transform {
+	return func(r *DtsRecord) (bool, error) {
+		index := r.fieldIndex(column)
+		if index < 0 {
+			return true, nil
+		}
+
+		// 两个镜像的值都转换成功后再写入, 避免改变后镜像转换失败时改变前镜像已经被修改
+		casted := make([]interface{}, 0, 2)
+		err := r.eachImage(func(array []interface{}) ([]interface{}, error) {
+			if index >= len(array) || array[index] == nil {
+				casted = append(casted, nil)
+				return array, nil
+			}
+
+			v, err := newImageValue(dataType, r.getColValue(array[index]))
+			if err != nil {
+				return nil, errors.Wrapf(err, "cast column %s", column)
+			}
+			casted = append(casted, v)
+			return array, nil
+		})
+		if err != nil {
+			return false, err
+		}
+
+		_ = r.eachImage(func(array []interface{}) ([]interface{}, error) {
+			if v := casted[0]; v != nil {
+				array[index] = v
+			}
+			casted = casted[1:]
+			return array, nil
+		})
+
+		fields := copyTableFields(r.TableFields)
+		fields[index].DataType = dataType
+		r.setTableFields(fields)
+		return true, nil
+	}
+}
+
+// AddColumn 增加一个常量列
+func AddColumn(column, value string) Transform {
+	return AddComputedColumn(column, func(*DtsRecord, map[string]string) (string, error) {
+		return value, nil
+	})
+}
+
+// AddComputedColumn 增加一个计算列, compute分别根据改变前和改变后镜像计算列的值
+func AddComputedColumn(column string, compute func(r *DtsRecord, cols map[string]string) (string, error)) Transform {
+	return func(r *DtsRecord) (bool, error) {
+		if r.fieldIndex(column) >= 0 {
+			return false, errors.Errorf("add column %s: column already exists", column)
+		}
+		if len(r.loadTableFields()) == 0 {
+			return true, nil
+		}
+
+		// 两个镜像的值都计算成功后再写入, 避免改变后镜像计算失败时改变前镜像已经被修改
+		imageList := []map[string]interface{}{r.BeforeImages, r.AfterImages}
+		values := make([]string, len(imageList))
+		for i, images := range imageList {
+			if _, ok := getImageArray(images); !ok {
+				continue
+			}
+
+			value, err := compute(r, r.getColumns(images))
+			if err != nil {
+				return false, errors.Wrapf(err, "compute column %s", column)
+			}
+			values[i] = value
+		}
+
+		for i, images := range imageList {
+			if array, ok := getImageArray(images); ok {
+				images["array"] = append(array, newCharacterValue(values[i]))
+			}
+		}
+
+		fields := copyTableFields(r.TableFields)
+		r.setTableFields(append(fields, &DtsField{Name: column, DataType: MYSQL_TYPE_VAR_STRING}))
+		return true, nil
+	}
+}
+
+// FilterRows 只保留predicate返回true的记录
+func FilterRows(predicate func(r *DtsRecord) bool) Transform {
+	return func(r *DtsRecord) (bool, error) {
+		return predicate(r), nil
+	}
+}
+
+// HashMasker 加盐后计算sha256, 相同的值脱敏后仍然相同, 可以用于关联
+func HashMasker(salt string) Masker {
+	return func(value string) string {
+		sum := sha256.Sum256([]byte(salt + value))
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+// RedactMasker 将值整体替换成固定的字符串
+func RedactMasker() Masker {
+	return func(string) string {
+		return REDACTED_VALUE
+	}
+}
+
+// KeepLastMasker 只保留最后n个字符, 例如手机号只保留后4位
+func KeepLastMasker(n int) Masker {
+	return PartialMasker(0, n)
+}
+
+// PartialMasker 保留前keepFirst个和后keepLast个字符, 其余字符替换成*
+func PartialMasker(keepFirst, keepLast int) Masker {
+	return func(value string) string {
+		runes := []rune(value)
+		if keepFirst+keepLast >= len(runes) {
+			return strings.Repeat("*", len(runes))
+		}
+		for i := keepFirst; i < len(runes)-keepLast; i++ {
+			runes[i] = '*'
+		}
+		return string(runes)
+	}
+}
+
+// maskValue 对字段在各个镜像中的值做脱敏
+func (r *DtsRecord) maskValue(index int, masker Masker) {
+	_ = r.eachImage(func(array []interface{}) ([]interface{}, error) {
+		if index < len(array) && array[index] != nil {
+			array[index] = newCharacterValue(masker(r.getColValue(array[index])))
+		}
+		return array, nil
+	})
+
+	if r.TableFields[index].DataType != MYSQL_TYPE_VAR_STRING {
+		fields := copyTableFields(r.TableFields)
+		fields[index].DataType = MYSQL_TYPE_VAR_STRING
+		r.setTableFields(fields)
+	}
+}
+
+// copyTableFields 复制字段列表, 避免修改被其他记录共享的字段
+func copyTableFields(fields []*DtsField) []*DtsField {
+	copied := make([]*DtsField, 0, len(fields))
+	for _, field := range fields {
+		f := DtsField{}
+		if field != nil {
+			f = *field
+		}
+		copied = append(copied, &f)
+	}
+	return copied
+}
@@ -0,0 +1,105 @@
+package alidts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransforms(t *testing.T) {
+	m := newTestInsert(1, 10, "tom", "tom@example.com")
+	m.operation = OPERATION_UPDATE
+	m.before = []interface{}{int64(10), "tommy", "tom@example.com"}
+	r := parseTestMessage(t, m)
+
+	transform := Chain(
+		RenameColumn("name", "nickname"),
+		DropColumns("email"),
+		MaskColumn("nickname", PartialMasker(1, 1)),
+		CastColumn("id", MYSQL_TYPE_VAR_STRING),
+		AddColumn("source", "dts"),
+	)
+	keep, err := transform(r)
+	assert.NoError(t, err)
+	assert.True(t, keep)
+
+	assert.Equal(t, map[string]string{"id": "10", "nickname": "t*m", "source": "dts"}, r.GetAfterColumns())
+	assert.Equal(t, map[string]string{"id": "10", "nickname": "t***y", "source": "dts"}, r.GetBeforeColumns())
+	assert.NoError(t, r.Validate())
+
+	_, err = CastColumn("nickname", MYSQL_TYPE_INT64)(r)
+	assert.Error(t, err)
+}
+
+func TestTransformsErrorKeepsImages(t *testing.T) {
+	m := newTestInsert(1, 10, "tom", "tom@example.com")
+	m.operation = OPERATION_UPDATE
+	m.before = []interface{}{int64(10), "42", "tom@example.com"}
+	r := parseTestMessage(t, m)
+	before, after := r.GetBeforeColumns(), r.GetAfterColumns()
+
+	// 改变后镜像计算失败时改变前镜像不能被修改
+	_, err := AddComputedColumn("domain", func(r *DtsRecord, cols map[string]string) (string, error) {
+		if cols["name"] == "tom" {
+			return "", errors.New("broken")
+		}
+		return cols["name"], nil
+	})(r)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "compute column domain")
+	}
+
+	// 改变后镜像转换失败时改变前镜像不能被修改
+	_, err = CastColumn("name", MYSQL_TYPE_INT64)(r)
+	assert.Error(t, err)
+
+	assert.Equal(t, before, r.GetBeforeColumns())
+	assert.Equal(t, after, r.GetAfterColumns())
+	assert.Len(t, r.loadTableFields(), 3)
+	assert.Equal(t, MYSQL_TYPE_VAR_STRING, r.loadTableFields()[1].DataType)
+	assert.NoError(t, r.Validate())
+}
+
+func TestLoadTransformer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	rules := `
+tables:
+  "*":
+    - drop: [email]
+  shop.user:
+    - mask: {column: name, strategy: hash, salt: s}
+    - filter: {column: id, op: ne, value: "20"}
+`
+	assert.NoError(t, os.WriteFile(path, []byte(rules), 0600))
+
+	transformer, err := LoadTransformer(path)
+	assert.NoError(t, err)
+
+	r := parseTestMessage(t, newTestInsert(1, 10, "tom", "tom@example.com"))
+	keep, err := transformer.Apply(r)
+	assert.NoError(t, err)
+	assert.True(t, keep)
+	assert.Equal(t, map[string]string{"id": "10", "name": HashMasker("s")("tom")}, r.GetAfterColumns())
+
+	r = parseTestMessage(t, newTestInsert(2, 20, "jerry", "jerry@example.com"))
+	keep, err = transformer.Apply(r)
+	assert.NoError(t, err)
+	assert.False(t, keep)
+}
+
+func TestMaskingPolicy(t *testing.T) {
+	policy, err := NewMaskingPolicy(MaskingPolicyConfig{
+		Rules: []MaskingRule{
+			{Columns: []string{"shop.user.name"}, Strategy: MASK_STRATEGY_KEEP_LAST, KeepLast: 2},
+		},
+		Detect: &DetectRule{Strategy: MASK_STRATEGY_REDACT},
+	})
+	assert.NoError(t, err)
+
+	r := parseTestMessage(t, newTestInsert(1, 10, "jerry", "jerry@example.com"))
+	policy.Apply(r)
+	assert.Equal(t, map[string]string{"id": "10", "name": "***ry", "email": REDACTED_VALUE}, r.GetAfterColumns())
+}
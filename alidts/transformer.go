@@ -0,0 +1,227 @@
+package alidts
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"utils"
+)
+
+// TRANSFORM_ALL_TABLES 对所有表生效的规则使用的表名
+const TRANSFORM_ALL_TABLES = "*"
+
+// Transformer 按表组织的变换, 先执行对所有表生效的变换, 再执行该表自己的变换
+type Transformer struct {
+	mu     sync.RWMutex
+	tables map[string][]Transform
+}
+
+// TransformRules 变换规则文件的内容, key为"数据库名.表名"或者"*"
+//
+//	tables:
+//	  shop.user:
+//	    - rename: {from: mobile, to: phone}
+//	    - drop: [password]
+//	    - mask: {column: phone, strategy: partial, keep_first: 3, keep_last: 4}
+//	    - cast: {column: age, type: int}
+//	    - add: {column: source, value: dts}
+//	    - filter: {column: status, op: ne, value: deleted}
+type TransformRules struct {
+	Tables map[string][]TransformRule `json:"tables" yaml:"tables"`
+}
+
+// TransformRule 一条变换规则, 每条规则只能设置一种变换
+type TransformRule struct {
+	Rename *RenameRule `json:"rename,omitempty" yaml:"rename,omitempty"`
+	Drop   []string    `json:"drop,omitempty" yaml:"drop,omitempty"`
+	Mask   *MaskRule   `json:"mask,omitempty" yaml:"mask,omitempty"`
+	Cast   *CastRule   `json:"cast,omitempty" yaml:"cast,omitempty"`
+	Add    *AddRule    `json:"add,omitempty" yaml:"add,omitempty"`
+	Filter *FilterRule `json:"filter,omitempty" yaml:"filter,omitempty"`
+}
+
+type RenameRule struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+// MaskRule 脱敏规则, strategy: hash, partial, redact, keep_last
+type MaskRule struct {
+	Column    string `json:"column" yaml:"column"`
+	Strategy  string `json:"strategy" yaml:"strategy"`
+	Salt      string `json:"salt,omitempty" yaml:"salt,omitempty"`
+	KeepFirst int    `json:"keep_first,omitempty" yaml:"keep_first,omitempty"`
+	KeepLast  int    `json:"keep_last,omitempty" yaml:"keep_last,omitempty"`
+}
+
+// CastRule 类型转换规则, type: string, int, decimal, float
+type CastRule struct {
+	Column string `json:"column" yaml:"column"`
+	Type   string `json:"type" yaml:"type"`
+}
+
+type AddRule struct {
+	Column string `json:"column" yaml:"column"`
+	Value  string `json:"value" yaml:"value"`
+}
+
+// FilterRule 过滤规则, 只保留满足条件的记录, op: eq, ne, in, not_in
+type FilterRule struct {
+	Column string   `json:"column" yaml:"column"`
+	Op     string   `json:"op" yaml:"op"`
+	Value  string   `json:"value,omitempty" yaml:"value,omitempty"`
+	Values []string `json:"values,omitempty" yaml:"values,omitempty"`
+}
+
+// 规则中类型名称对应的mysql类型
+var castTypes = map[string]int{
+	"string":  MYSQL_TYPE_VAR_STRING,
+	"int":     MYSQL_TYPE_INT64,
+	"decimal": MYSQL_TYPE_NEWDECIMAL,
+	"float":   MYSQL_TYPE_DOUBLE,
+}
+
+func NewTransformer() *Transformer {
+	return &Transformer{
+		tables: make(map[string][]Transform),
+	}
+}
+
+// LoadTransformer 从yaml或者json规则文件中创建Transformer
+func LoadTransformer(path string) (*Transformer, error) {
+	var rules TransformRules
+	err := decodeRulesFile(path, &rules)
+	if err != nil {
+		return nil, errors.Wrap(err, "load transform rules")
+	}
+
+	return NewTransformerFromRules(rules)
+}
+
+// NewTransformerFromRules 根据规则创建Transformer
+func NewTransformerFromRules(rules TransformRules) (*Transformer, error) {
+	t := NewTransformer()
+	for table, tableRules := range rules.Tables {
+		for i, rule := range tableRules {
+			transform, err := rule.build()
+			if err != nil {
+				return nil, errors.Wrapf(err, "table: %s, rule: %d", table, i)
+			}
+			t.Add(table, transform)
+		}
+	}
+	return t, nil
+}
+
+// Add 为表增加变换, table为"数据库名.表名", 或者"*"表示所有的表
+func (t *Transformer) Add(table string, transforms ...Transform) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tables[table] = append(t.tables[table], transforms...)
+}
+
+// Apply 对记录执行变换, 返回false表示记录被过滤掉
+func (t *Transformer) Apply(r *DtsRecord) (bool, error) {
+	t.mu.RLock()
+	transforms := make([]Transform, 0)
+	transforms = append(transforms, t.tables[TRANSFORM_ALL_TABLES]...)
+	if r.Table != "" {
+		transforms = append(transforms, t.tables[r.GetFullTableName()]...)
+	}
+	t.mu.RUnlock()
+
+	return Chain(transforms...)(r)
+}
+
+func (rule TransformRule) build() (Transform, error) {
+	var transforms []Transform
+
+	if rule.Rename != nil {
+		transforms = append(transforms, RenameColumn(rule.Rename.From, rule.Rename.To))
+	}
+
+	if len(rule.Drop) > 0 {
+		transforms = append(transforms, DropColumns(rule.Drop...))
+	}
+
+	if rule.Mask != nil {
+		masker, err := rule.Mask.masker()
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, MaskColumn(rule.Mask.Column, masker))
+	}
+
+	if rule.Cast != nil {
+		dataType, exist := castTypes[rule.Cast.Type]
+		if !exist {
+			return nil, errors.Errorf("unsupported cast type: %s", rule.Cast.Type)
+		}
+		transforms = append(transforms, CastColumn(rule.Cast.Column, dataType))
+	}
+
+	if rule.Add != nil {
+		transforms = append(transforms, AddColumn(rule.Add.Column, rule.Add.Value))
+	}
+
+	if rule.Filter != nil {
+		predicate, err := rule.Filter.predicate()
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, FilterRows(predicate))
+	}
+
+	if len(transforms) != 1 {
+		return nil, errors.New("each rule must define exactly one transform")
+	}
+	return transforms[0], nil
+}
+
+func (rule *MaskRule) masker() (Masker, error) {
+	return newMasker(rule.Strategy, rule.Salt, rule.KeepFirst, rule.KeepLast)
+}
+
+func (rule *FilterRule) predicate() (func(r *DtsRecord) bool, error) {
+	var match func(value string) bool
+	switch rule.Op {
+	case "eq":
+		match = func(value string) bool { return value == rule.Value }
+	case "ne":
+		match = func(value string) bool { return value != rule.Value }
+	case "in":
+		match = func(value string) bool { return utils.IsStringContains(rule.Values, value) }
+	case "not_in":
+		match = func(value string) bool { return !utils.IsStringContains(rule.Values, value) }
+	default:
+		return nil, errors.Errorf("unsupported filter op: %s", rule.Op)
+	}
+
+	return func(r *DtsRecord) bool {
+		cols := r.currentColumns()
+		if cols == nil {
+			// 非DML记录不做过滤
+			return true
+		}
+		return match(cols[rule.Column])
+	}, nil
+}
+
+// decodeRulesFile 解析规则文件, 根据扩展名判断文件格式, .yaml/.yml为yaml, 其他为json
+func decodeRulesFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,102 @@
+package alidts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 校验规则名称
+const (
+	RULE_OBJECT_NAME  = "object_name"  // DML记录必须有数据库名和表名
+	RULE_FIELDS       = "fields"       // DML记录必须有字段定义
+	RULE_BEFORE_IMAGE = "before_image" // 改变前镜像是否应该存在
+	RULE_AFTER_IMAGE  = "after_image"  // 改变后镜像是否应该存在
+	RULE_IMAGE_LENGTH = "image_length" // 镜像值的个数必须和字段个数相同
+	RULE_DATA_TYPE    = "data_type"    // 字段类型必须是已知的类型
+)
+
+// Violation 违反的一条校验规则
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// ValidationError 记录校验失败的错误, 包含所有违反的规则
+type ValidationError struct {
+	Id         int64
+	Operation  string
+	Violations []Violation
+}
+
+// Error 实现Error接口
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", v.Rule, v.Message))
+	}
+	return fmt.Sprintf("invalid dts record, id: %d, operation: %s, violations: [%s]", e.Id, e.Operation, strings.Join(msgs, "; "))
+}
+
+// 各种DML操作对镜像的要求, true: 必须存在, false: 必须不存在
+var imageRules = map[string]struct{ before, after bool }{
+	OPERATION_INSERT: {before: false, after: true},
+	OPERATION_FILL:   {before: false, after: true},
+	OPERATION_UPDATE: {before: true, after: true},
+	OPERATION_DELETE: {before: true, after: false},
+}
+
+// Validate 按照操作类型校验记录的结构
+// 校验通过返回nil, 否则返回*ValidationError, 里面包含了所有违反的规则
+func (r *DtsRecord) Validate() error {
+	var violations []Violation
+	addViolation := func(rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	rule, isDML := imageRules[r.Operation]
+	if isDML {
+		if r.Database == "" || r.Table == "" {
+			addViolation(RULE_OBJECT_NAME, "missing database or table name, objectName: %q", r.ObjectName["string"])
+		}
+
+		fields := r.loadTableFields()
+		if len(fields) == 0 {
+			addViolation(RULE_FIELDS, "missing field definitions")
+		}
+
+		for i, field := range fields {
+			if field == nil {
+				addViolation(RULE_FIELDS, "field %d is empty", i)
+				continue
+			}
+			if !IsKnownMysqlType(field.DataType) {
+				addViolation(RULE_DATA_TYPE, "field %s has unknown data type %d", field.Name, field.DataType)
+			}
+		}
+
+		before, hasBefore := getImageArray(r.BeforeImages)
+		after, hasAfter := getImageArray(r.AfterImages)
+		if rule.before != hasBefore {
+			addViolation(RULE_BEFORE_IMAGE, "%s expects before image present=%t, got %t", r.Operation, rule.before, hasBefore)
+		}
+		if rule.after != hasAfter {
+			addViolation(RULE_AFTER_IMAGE, "%s expects after image present=%t, got %t", r.Operation, rule.after, hasAfter)
+		}
+		if hasBefore && len(before) != len(fields) {
+			addViolation(RULE_IMAGE_LENGTH, "before image has %d values, expected %d", len(before), len(fields))
+		}
+		if hasAfter && len(after) != len(fields) {
+			addViolation(RULE_IMAGE_LENGTH, "after image has %d values, expected %d", len(after), len(fields))
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	return &ValidationError{
+		Id:         r.Id,
+		Operation:  r.Operation,
+		Violations: violations,
+	}
+}
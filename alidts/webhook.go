@@ -0,0 +1,232 @@
+package alidts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// WEBHOOK_SIGNATURE_HEADER 请求体签名的header, 值为"sha256=" + hex(HMAC-SHA256(secret, body))
+	WEBHOOK_SIGNATURE_HEADER = "X-Dts-Signature"
+	webhookSignaturePrefix   = "sha256="
+)
+
+// WebhookEndpoint 接收推送的地址
+type WebhookEndpoint struct {
+	URL         string
+	Secret      string            // 为空时不签名
+	Concurrency int               // 同时向该地址发送的最大请求数, 默认为1
+	Headers     map[string]string // 额外的请求头
+}
+
+type webhookEndpoint struct {
+	WebhookEndpoint
+	sem chan struct{}
+}
+
+// WebhookSink 将记录以json的形式推送到webhook地址
+// 单条记录推送一个json对象, 批量推送一个json数组, 数组中的元素为ChangeEvent
+// 请求失败时按指数退避重试, 重试全部失败后写入死信
+type WebhookSink struct {
+	endpoints []*webhookEndpoint
+
+	// Client 发送请求使用的http客户端
+	Client *http.Client
+	// MaxRetries 请求失败时的重试次数
+	MaxRetries int
+	// RetryBackoff 第一次重试的等待时间, 之后每次翻倍, 最长不超过MaxBackoff
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	// DeadLetter 不为nil时, 重试全部失败的请求体写入死信, Topic为webhook地址, 否则返回错误
+	DeadLetter *DeadLetterWriter
+}
+
+func NewWebhookSink(endpoints ...WebhookEndpoint) *WebhookSink {
+	s := &WebhookSink{
+		Client:       http.DefaultClient,
+		MaxRetries:   3,
+		RetryBackoff: 200 * time.Millisecond,
+		MaxBackoff:   10 * time.Second,
+	}
+
+	for _, endpoint := range endpoints {
+		if endpoint.Concurrency <= 0 {
+			endpoint.Concurrency = 1
+		}
+		s.endpoints = append(s.endpoints, &webhookEndpoint{
+			WebhookEndpoint: endpoint,
+			sem:             make(chan struct{}, endpoint.Concurrency),
+		})
+	}
+	return s
+}
+
+// Send 推送单条记录到所有的地址
+func (s *WebhookSink) Send(ctx context.Context, r *DtsRecord) error {
+	body, err := json.Marshal(NewChangeEvent(r))
+	if err != nil {
+		return errors.Wrap(err, "encode webhook body")
+	}
+	return s.post(ctx, body)
+}
+
+// SendBatch 将多条记录作为一个json数组推送到所有的地址
+func (s *WebhookSink) SendBatch(ctx context.Context, records []*DtsRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	events := make([]*ChangeEvent, 0, len(records))
+	for _, r := range records {
+		events = append(events, NewChangeEvent(r))
+	}
+
+	body, err := json.Marshal(events)
+	if err != nil {
+		return errors.Wrap(err, "encode webhook body")
+	}
+	return s.post(ctx, body)
+}
+
+// post 并行地向所有地址发送请求, 返回第一个错误
+func (s *WebhookSink) post(ctx context.Context, body []byte) error {
+	errs := make([]error, len(s.endpoints))
+
+	var wg sync.WaitGroup
+	for i, endpoint := range s.endpoints {
+		wg.Add(1)
+		go func(i int, endpoint *webhookEndpoint) {
+			defer wg.Done()
+			errs[i] = s.postEndpoint(ctx, endpoint, body)
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WebhookSink) postEndpoint(ctx context.Context, endpoint *webhookEndpoint, body []byte) error {
+	err := s.send(ctx, endpoint, body)
+	if err == nil || s.DeadLetter == nil {
+		return err
+	}
+	return s.DeadLetter.Write(body, err, DeadLetterMeta{Topic: endpoint.URL, Kind: DEAD_LETTER_KIND_WEBHOOK})
+}
+
+// send 向一个地址发送请求, 失败时按指数退避重试
+func (s *WebhookSink) send(ctx context.Context, endpoint *webhookEndpoint, body []byte) error {
+	select {
+	case endpoint.sem <- struct{}{}:
+		defer func() { <-endpoint.sem }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var err error
+	backoff := s.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err = s.do(ctx, endpoint, body)
+		if err == nil {
+			return nil
+		}
+
+		if _, ok := err.(permanentError); ok || attempt >= s.MaxRetries {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+			if s.MaxBackoff > 0 && backoff > s.MaxBackoff {
+				backoff = s.MaxBackoff
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return errors.Wrapf(err, "post webhook, url: %s", endpoint.URL)
+}
+
+// ReplayDeadLetters 将目录中webhook的死信重新推送到原来的地址, 一般在接收方恢复后执行
+// 再次失败的死信会写入failed, failed为nil时遇到失败直接返回错误, 重放时不会写入DeadLetter
+// 原来的地址已经不在endpoints中或者不是webhook的死信视为失败, ctx被取消时直接返回, 当前文件下次会重新重放
+func (s *WebhookSink) ReplayDeadLetters(ctx context.Context, dir string, failed *DeadLetterWriter) error {
+	return replayDeadLetters(dir, failed, func(letter *DeadLetter) error {
+		if letter.Kind != DEAD_LETTER_KIND_WEBHOOK {
+			return errors.Errorf("dead letter of kind %q is not a webhook body", letter.Kind)
+		}
+
+		for _, endpoint := range s.endpoints {
+			if endpoint.URL == letter.Topic {
+				return s.send(ctx, endpoint, letter.Data)
+			}
+		}
+		return errors.Errorf("unknown webhook endpoint: %s", letter.Topic)
+	})
+}
+
+func (s *WebhookSink) do(ctx context.Context, endpoint *webhookEndpoint, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range endpoint.Headers {
+		req.Header.Set(k, v)
+	}
+	if endpoint.Secret != "" {
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, SignWebhookBody(endpoint.Secret, body))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = errors.Errorf("unexpected status: %d, body: %s", resp.StatusCode, data)
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return err
+	}
+	return permanentError{err}
+}
+
+// SignWebhookBody 计算请求体的签名
+func SignWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature 校验请求体的签名, 供接收方使用
+func VerifyWebhookSignature(secret string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, webhookSignaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(SignWebhookBody(secret, body)), []byte(signature))
+}
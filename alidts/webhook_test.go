@@ -0,0 +1,168 @@
+package alidts
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSink(t *testing.T) {
+	var requests, active, maxActive int32
+	var mu sync.Mutex
+	bodies := make([][]byte, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		current := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			old := atomic.LoadInt32(&maxActive)
+			if current <= old || atomic.CompareAndSwapInt32(&maxActive, old, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		body, _ := io.ReadAll(req.Body)
+		assert.True(t, VerifyWebhookSignature("secret", body, req.Header.Get(WEBHOOK_SIGNATURE_HEADER)))
+
+		// 第一个请求失败, 需要重试
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(WebhookEndpoint{URL: server.URL, Secret: "secret", Concurrency: 2})
+	sink.RetryBackoff = time.Millisecond
+
+	// parseTestMessage失败时调用t.Fatal, 只能在测试的goroutine中解析
+	ctx := context.Background()
+	records := make([]*DtsRecord, 0, 6)
+	for i := int64(1); i <= 6; i++ {
+		records = append(records, parseTestMessage(t, newTestInsert(i, i, "tom", "tom@example.com")))
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range records {
+		wg.Add(1)
+		go func(r *DtsRecord) {
+			defer wg.Done()
+			assert.NoError(t, sink.Send(ctx, r))
+		}(r)
+	}
+	wg.Wait()
+
+	assert.Len(t, bodies, 6)
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxActive), int32(2))
+
+	records = []*DtsRecord{
+		parseTestMessage(t, newTestInsert(7, 7, "tom", "tom@example.com")),
+		parseTestMessage(t, newTestInsert(8, 8, "jerry", "jerry@example.com")),
+	}
+	assert.NoError(t, sink.SendBatch(ctx, records))
+
+	var events []ChangeEvent
+	assert.NoError(t, json.Unmarshal(bodies[len(bodies)-1], &events))
+	assert.Len(t, events, 2)
+	assert.Equal(t, "jerry", *events[1].After["name"])
+}
+
+func TestWebhookSinkDeadLetter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	deadLetter, err := NewDeadLetterWriter(dir, 0, 0)
+	assert.NoError(t, err)
+	defer deadLetter.Close()
+
+	sink := NewWebhookSink(WebhookEndpoint{URL: server.URL})
+	sink.DeadLetter = deadLetter
+	assert.NoError(t, sink.Send(context.Background(), parseTestMessage(t, newTestInsert(1, 1, "tom", "tom@example.com"))))
+
+	letters := make([]*DeadLetter, 0)
+	assert.NoError(t, ReadDeadLetters(dir, func(letter *DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	}))
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, server.URL, letters[0].Topic)
+		assert.Equal(t, DEAD_LETTER_KIND_WEBHOOK, letters[0].Kind)
+		assert.Contains(t, letters[0].Error, "400")
+	}
+
+	// webhook的死信不是avro消息, 不能按照DTS消息重放
+	assert.NoError(t, deadLetter.Close())
+	ad, err := New()
+	assert.NoError(t, err)
+	err = ad.ReplayDeadLetters(dir, func(r *DtsRecord, letter *DeadLetter) error {
+		return nil
+	}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not a dts message")
+}
+
+func TestWebhookSinkReplayDeadLetters(t *testing.T) {
+	var fail int32 = 1
+	bodies := make(chan []byte, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	deadLetter, err := NewDeadLetterWriter(dir, 0, 0)
+	assert.NoError(t, err)
+
+	r := parseTestMessage(t, newTestInsert(1, 1, "tom", "tom@example.com"))
+	sink := NewWebhookSink(WebhookEndpoint{URL: server.URL})
+	sink.DeadLetter = deadLetter
+	assert.NoError(t, sink.Send(context.Background(), r))
+	assert.NoError(t, deadLetter.Write([]byte("gone"), nil, DeadLetterMeta{Topic: "http://127.0.0.1:1/gone", Kind: DEAD_LETTER_KIND_WEBHOOK}))
+	assert.NoError(t, deadLetter.Close())
+
+	// 接收方恢复后重放, 地址不存在的死信写入failed
+	atomic.StoreInt32(&fail, 0)
+	failedDir := t.TempDir()
+	failed, err := NewDeadLetterWriter(failedDir, 0, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.ReplayDeadLetters(context.Background(), dir, failed))
+	assert.NoError(t, failed.Close())
+
+	if assert.Len(t, bodies, 1) {
+		var event ChangeEvent
+		assert.NoError(t, json.Unmarshal(<-bodies, &event))
+		assert.Equal(t, r.Id, event.Id)
+		assert.Equal(t, "tom", *event.After["name"])
+	}
+
+	letters := readTestDeadLetters(t, failedDir)
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, "gone", string(letters[0].Data))
+		assert.Equal(t, DEAD_LETTER_KIND_WEBHOOK, letters[0].Kind)
+		assert.Contains(t, letters[0].Error, "unknown webhook endpoint")
+	}
+
+	// 已经重放的文件不会再次重放
+	assert.NoError(t, sink.ReplayDeadLetters(context.Background(), dir, nil))
+	assert.Len(t, bodies, 0)
+}
@@ -0,0 +1,148 @@
+// dtsgen 根据DTS消息中的表结构生成Go结构体
+//
+// 从DTS消息样本生成, 每个文件保存一条原始的DTS消息, 目录会被递归读取:
+//
+//	dtsgen -package model -output model/tables.go samples/
+//
+// 从表的字段定义生成:
+//
+//	dtsgen -fields fields.json
+//
+// fields.json的格式为:
+//
+//	{"shop.user": [{"name": "id", "dataTypeNumber": 8}, {"name": "email", "dataTypeNumber": 253, "nullable": true}]}
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"utils"
+	"utils/alidts"
+)
+
+type fieldDefinition struct {
+	Name           string `json:"name"`
+	DataTypeNumber int    `json:"dataTypeNumber"`
+	Nullable       bool   `json:"nullable"`
+}
+
+func main() {
+	pkg := flag.String("package", "model", "package name of the generated code")
+	output := flag.String("output", "", "output file, default is stdout")
+	fieldsFile := flag.String("fields", "", "json file of table field definitions")
+	tables := flag.String("tables", "", "comma separated db.table list to generate, default is all tables")
+	flag.Parse()
+
+	collector := alidts.NewSchemaCollector()
+
+	if *fieldsFile != "" {
+		err := loadFields(collector, *fieldsFile)
+		if err != nil {
+			utils.Fatal("load field definitions", "file", *fieldsFile, "err", err)
+		}
+	}
+
+	if flag.NArg() > 0 {
+		ad, err := alidts.New()
+		if err != nil {
+			utils.Fatal("new alidts", "err", err)
+		}
+
+		for _, path := range flag.Args() {
+			err = loadSamples(ad, collector, path)
+			if err != nil {
+				utils.Fatal("load samples", "path", path, "err", err)
+			}
+		}
+	}
+
+	schemas := collector.Tables()
+	if *tables != "" {
+		wanted := strings.Split(*tables, ",")
+		filtered := make([]alidts.TableSchema, 0)
+		for _, schema := range schemas {
+			if utils.IsStringContains(wanted, schema.Database+"."+schema.Table) {
+				filtered = append(filtered, schema)
+			}
+		}
+		schemas = filtered
+	}
+
+	if len(schemas) == 0 {
+		utils.Fatal("no table schema found")
+	}
+
+	src, err := alidts.GenerateStructs(*pkg, schemas)
+	if err != nil {
+		utils.Fatal("generate structs", "err", err)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(src)
+		return
+	}
+
+	err = os.WriteFile(*output, src, 0644)
+	if err != nil {
+		utils.Fatal("write output", "file", *output, "err", err)
+	}
+}
+
+// loadFields 从字段定义文件中加载表结构
+func loadFields(collector *alidts.SchemaCollector, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var definitions map[string][]fieldDefinition
+	err = json.Unmarshal(data, &definitions)
+	if err != nil {
+		return err
+	}
+
+	for name, fields := range definitions {
+		tokens := strings.SplitN(name, ".", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+
+		table := alidts.TableSchema{Database: tokens[0], Table: tokens[1]}
+		for _, field := range fields {
+			table.Columns = append(table.Columns, alidts.ColumnSchema{
+				Name:     field.Name,
+				DataType: field.DataTypeNumber,
+				Nullable: field.Nullable,
+			})
+		}
+		collector.AddTable(table)
+	}
+	return nil
+}
+
+// loadSamples 从DTS消息样本中收集表结构, 无法解析的文件会被忽略
+func loadSamples(ad *alidts.AliDts, collector *alidts.SchemaCollector, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		r, err := ad.Parse(data)
+		if err != nil {
+			utils.Print("error", "skip unparseable sample", "file", path, "err", err)
+			return nil
+		}
+
+		collector.Add(r)
+		return nil
+	})
+}